@@ -1,18 +1,27 @@
 package main
 
 import (
+    "context"
     // "encoding/json"
     // "fmt"
-    // "log"
-    // "net/http"
+    "log/slog"
+    "net/http"
     "os"
     "strconv"
-    // "time"
+    "time"
 
     "github.com/m1tka051209/arithmetic-service/agent/worker"
+    "github.com/m1tka051209/arithmetic-service/logging"
+    "github.com/m1tka051209/arithmetic-service/tracing"
 )
 
 func main() {
+    logging.Setup()
+    if err := tracing.Setup(context.Background(), "agent"); err != nil {
+        slog.Error("failed to configure tracing", "err", err)
+        os.Exit(1)
+    }
+
     // Получаем значение переменной окружения и конвертируем в int
     powerStr := os.Getenv("COMPUTING_POWER")
     power, err := strconv.Atoi(powerStr)
@@ -21,4 +30,18 @@ func main() {
     }
 
     worker.StartWorkers(power)
+
+    // Метрики агента; заодно не даём main завершиться, пока работают воркеры
+    addr := os.Getenv("AGENT_METRICS_ADDR")
+    if addr == "" {
+        addr = ":9090"
+    }
+    http.Handle("GET /metrics", worker.MetricsHandler())
+    slog.Info("agent started", "workers", power, "metrics_addr", addr)
+    err = http.ListenAndServe(addr, nil)
+    slog.Error("metrics server stopped", "err", err)
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    tracing.Shutdown(ctx)
+    cancel()
+    os.Exit(1)
 }
\ No newline at end of file
@@ -4,9 +4,14 @@ package models
 
 // Task - структура задачи для вычислительного агента
 type Task struct {
-    ID            string    `json:"id"`
-    Arg1          float64   `json:"arg1"`
-    Arg2          float64   `json:"arg2"`
-    Operation     string    `json:"operation"`
-    OperationTime int       `json:"operation_time"` // Время выполнения в миллисекундах
+    ID              string            `json:"id"`
+    ExpressionID    string            `json:"expression_id"`
+    Arg1            Value             `json:"arg1"`
+    Arg2            Value             `json:"arg2"`
+    Operation       string            `json:"operation"`
+    OperationTime   int               `json:"operation_time"`    // Время выполнения в миллисекундах
+    Numeric         string            `json:"numeric"`           // "float", "rational" или "complex"
+    NonFinite       string            `json:"non_finite"`        // "error" или "ieee"
+    MaxRationalBits int               `json:"max_rational_bits"` // предел размера дроби в битах; 0 — по умолчанию
+    TraceContext    map[string]string `json:"trace_context"`     // контекст трассы выражения (W3C traceparent)
 }
\ No newline at end of file
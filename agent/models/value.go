@@ -0,0 +1,32 @@
+package models
+
+import "github.com/m1tka051209/arithmetic-service/calc"
+
+// Значения задач и их режимы определены в пакете calc, общем с оркестратором
+type (
+	Value     = calc.Value
+	JSONFloat = calc.JSONFloat
+)
+
+const (
+	NumericFloat    = calc.NumericFloat
+	NumericRational = calc.NumericRational
+	NumericComplex  = calc.NumericComplex
+	KindBool        = calc.KindBool
+
+	NonFiniteError = calc.NonFiniteError
+	NonFiniteIEEE  = calc.NonFiniteIEEE
+
+	ErrorDivisionByZero  = calc.ErrorDivisionByZero
+	ErrorOverflow        = calc.ErrorOverflow
+	ErrorNaN             = calc.ErrorNaN
+	ErrorInvalidArgument = calc.ErrorInvalidArgument
+	ErrorTooLarge        = calc.ErrorTooLarge
+)
+
+var (
+	Float    = calc.Float
+	Rational = calc.Rational
+	Complex  = calc.Complex
+	Bool     = calc.Bool
+)
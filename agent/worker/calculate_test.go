@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m1tka051209/arithmetic-service/agent/models"
+)
+
+// Задачи в том виде, в каком их присылает /internal/task
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		task   string
+		result string // результат в JSON, если code пуст
+		code   string
+	}{
+		{`{"operation": "+", "arg1": 2, "arg2": 3}`, `5`, ""},
+		{`{"operation": "/", "arg1": "1/3", "arg2": "2/3", "numeric": "rational"}`, `"1/2"`, ""},
+		{`{"operation": "/", "arg1": "1/3", "arg2": "0", "numeric": "rational", "non_finite": "ieee"}`, "", models.ErrorDivisionByZero},
+		{`{"operation": "+", "arg1": "1/3", "numeric": "rational"}`, "", models.ErrorInvalidArgument},
+		{`{"operation": "/", "arg1": "1/3", "arg2": null, "numeric": "rational"}`, "", models.ErrorInvalidArgument},
+		{`{"operation": "*", "arg1": "1/65536", "arg2": "1/65536", "numeric": "rational", "max_rational_bits": 32}`, "", models.ErrorTooLarge},
+		{`{"operation": "*", "arg1": [1, 2], "arg2": [3, -1], "numeric": "complex"}`, `[5,5]`, ""},
+		{`{"operation": "sqrt", "arg1": [-4, 0], "numeric": "complex"}`, `[0,2]`, ""},
+		{`{"operation": ">=", "arg1": "1/2", "arg2": "2/4", "numeric": "rational"}`, `true`, ""},
+		{`{"operation": "!", "arg1": true}`, `false`, ""},
+		{`{"operation": "/", "arg1": 1, "arg2": 0, "non_finite": "error"}`, "", models.ErrorDivisionByZero},
+		{`{"operation": "sqrt", "arg1": -1, "non_finite": "error"}`, "", models.ErrorNaN},
+		{`{"operation": "*", "arg1": 1e308, "arg2": 10, "non_finite": "error"}`, "", models.ErrorOverflow},
+		{`{"operation": "/", "arg1": -1, "arg2": 0, "non_finite": "ieee"}`, `"-Inf"`, ""},
+		{`{"operation": "-", "arg1": "+Inf", "arg2": "+Inf", "non_finite": "ieee"}`, `"NaN"`, ""},
+	}
+	for _, tt := range tests {
+		var task Task
+		require.NoError(t, json.Unmarshal([]byte(tt.task), &task), tt.task)
+
+		result, code := calculate(task)
+		assert.Equal(t, tt.code, code, tt.task)
+		if tt.code == "" {
+			data, err := json.Marshal(result)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.result, string(data), tt.task)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/m1tka051209/arithmetic-service/metrics"
+)
+
+var (
+	registry = metrics.NewRegistry()
+
+	busySeconds = registry.NewCounter("arithmetic_agent_worker_busy_seconds_total",
+		"Time each worker spent computing and submitting tasks.", "worker")
+	tasksDone = registry.NewCounter("arithmetic_agent_tasks_total",
+		"Tasks computed by the agent; outcome is completed or the error code sent instead of a result.", "operation", "outcome")
+	fetchErrors = registry.NewCounter("arithmetic_agent_fetch_errors_total",
+		"Failed requests for a task, not counting the absence of tasks.")
+	submitErrors = registry.NewCounter("arithmetic_agent_submit_errors_total",
+		"Failed result submissions.")
+)
+
+// MetricsHandler — метрики агента в формате Prometheus
+func MetricsHandler() http.Handler {
+	return metrics.Handler(registry)
+}
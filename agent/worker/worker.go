@@ -2,53 +2,174 @@ package worker
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m1tka051209/arithmetic-service/agent/models"
+	"github.com/m1tka051209/arithmetic-service/calc"
+	"github.com/m1tka051209/arithmetic-service/logging"
 )
 
-type Task struct {
-	ID            string  `json:"id"`
-	Arg1          float64 `json:"arg1"`
-	Arg2          float64 `json:"arg2"`
-	Operation     string  `json:"operation"`
-	OperationTime int     `json:"operation_time"`
+type Task = models.Task
+
+// errNoTask — у оркестратора нет задач; это не ошибка получения задачи
+var errNoTask = errors.New("no tasks available")
+
+var (
+	orchestratorURL = "http://localhost:8080"
+	client          = http.DefaultClient
+)
+
+// NewClient возвращает HTTP-клиент агента. certFile и keyFile — сертификат
+// агента для mTLS (CN становится ID агента в оркестраторе), caFile — CA,
+// которым подписан сертификат оркестратора; пусто — системные корневые.
+func NewClient(certFile, keyFile, caFile string) (*http.Client, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load agent certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", caFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
 }
 
+// StartWorkers запускает power воркеров. Адрес оркестратора берётся из
+// ORCHESTRATOR_URL, сертификаты для mTLS — из AGENT_TLS_CERT, AGENT_TLS_KEY
+// и AGENT_TLS_CA.
 func StartWorkers(power int) {
+	if url := os.Getenv("ORCHESTRATOR_URL"); url != "" {
+		orchestratorURL = strings.TrimSuffix(url, "/")
+	}
+	var err error
+	client, err = NewClient(os.Getenv("AGENT_TLS_CERT"), os.Getenv("AGENT_TLS_KEY"), os.Getenv("AGENT_TLS_CA"))
+	if err != nil {
+		slog.Error("failed to configure HTTP client", "err", err)
+		os.Exit(1)
+	}
+
+	// Один ID на процесс: воркеры одного агента не считаются разными
+	// агентами при проверке результатов. Оркестратор доверяет ему только
+	// без ключей доступа и сертификатов, иначе ID — имя ключа или CN.
+	host, _ := os.Hostname()
+	agentID := fmt.Sprintf("%s-%d", host, os.Getpid())
 	for i := 0; i < power; i++ {
 		go func(workerID int) {
+			label := strconv.Itoa(workerID)
+			logger := slog.With("worker", workerID, "agent_id", agentID)
 			for {
-				task, err := getTask()
+				task, err := getTask(requestContext(context.Background()), agentID)
 				if err != nil {
-					log.Printf("Worker %d: %v", workerID, err)
+					if errors.Is(err, errNoTask) {
+						logger.Debug("no tasks available")
+					} else {
+						fetchErrors.Inc()
+						logger.Warn("failed to fetch task", "err", err)
+					}
 					time.Sleep(2 * time.Second)
 					continue
 				}
 
-				log.Printf("Worker %d: Processing task %s", workerID, task.ID)
-				time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
-				result := calculate(task)
+				taskLogger := logger.With("task_id", task.ID, "expression_id", task.ExpressionID)
+				taskLogger.Debug("task received", "operation", task.Operation)
+				start := time.Now()
+				// Спаны агента продолжают трассу выражения, полученную вместе с задачей
+				ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(task.TraceContext))
+				ctx, span := tracer().Start(ctx, "execute task", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+					attribute.String("task.id", task.ID),
+					attribute.String("task.operation", task.Operation),
+					attribute.String("agent.id", agentID),
+				))
+				result, code := execute(ctx, task)
+				outcome := code
+				if outcome == "" {
+					outcome = "completed"
+				}
+				span.SetAttributes(attribute.String("task.outcome", outcome))
+				tasksDone.Inc(task.Operation, outcome)
 
-				if err := submitResult(task.ID, result); err != nil {
-					log.Printf("Worker %d: Submit error: %v", workerID, err)
+				ctx = requestContext(ctx)
+				if err := submitResult(ctx, agentID, task.ID, result, code); err != nil {
+					submitErrors.Inc()
+					taskLogger.WarnContext(ctx, "failed to submit result", "err", err)
+				} else {
+					taskLogger.DebugContext(ctx, "result submitted", "outcome", outcome, "duration", time.Since(start))
 				}
+				span.End()
+				busySeconds.Add(time.Since(start).Seconds(), label)
 			}
 		}(i)
 	}
 }
 
-func getTask() (Task, error) {
-	resp, err := http.Get("http://localhost:8080/internal/task")
+// requestContext — контекст запроса к оркестратору с новым ID запроса:
+// по нему записи журнала агента находятся в журнале оркестратора
+func requestContext(parent context.Context) context.Context {
+	return logging.WithRequestID(parent, logging.NewRequestID())
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/m1tka051209/arithmetic-service/agent/worker")
+}
+
+// execute выполняет задачу. Время операции имитируется паузой,
+// которая записывается отдельным спаном.
+func execute(ctx context.Context, task Task) (models.Value, string) {
+	_, span := tracer().Start(ctx, "operation time", trace.WithAttributes(
+		attribute.Int("task.operation_time_ms", task.OperationTime),
+	))
+	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+	span.End()
+	return calculate(task)
+}
+
+func getTask(ctx context.Context, agentID string) (Task, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, orchestratorURL+"/internal/task", nil)
+	if err != nil {
+		return Task{}, err
+	}
+	req.Header.Set("X-Agent-ID", agentID)
+	setHeaders(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return Task{}, fmt.Errorf("failed to fetch task: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return Task{}, fmt.Errorf("no tasks available")
+		return Task{}, errNoTask
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Task{}, fmt.Errorf("unexpected status: %d", resp.StatusCode)
 	}
 
 	var response struct {
@@ -61,39 +182,64 @@ func getTask() (Task, error) {
 	return response.Task, nil
 }
 
-func calculate(task Task) float64 {
-	switch task.Operation {
-	case "+":
-		return task.Arg1 + task.Arg2
-	case "-":
-		return task.Arg1 - task.Arg2
-	case "*":
-		return task.Arg1 * task.Arg2
-	case "/":
-		if task.Arg2 == 0 {
-			return 0
-		}
-		return task.Arg1 / task.Arg2
-	default:
-		return task.Arg1
+// setHeaders передаёт оркестратору ключ агента из AGENT_API_KEY,
+// ID запроса и контекст трассы из контекста запроса
+func setHeaders(req *http.Request) {
+	if key := os.Getenv("AGENT_API_KEY"); key != "" {
+		req.Header.Set("X-API-Key", key)
 	}
+	if id := logging.RequestID(req.Context()); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+}
+
+// calculate выполняет задачу по общим с оркестратором правилам пакета calc.
+// Если результат не является конечным числом и оркестратор не просил
+// IEEE-семантику, вместо него возвращается код ошибки.
+func calculate(task Task) (models.Value, string) {
+	return calc.Calculate(calc.Task{
+		Operation:       task.Operation,
+		Arg1:            task.Arg1,
+		Arg2:            task.Arg2,
+		Numeric:         task.Numeric,
+		NonFinite:       task.NonFinite,
+		MaxRationalBits: task.MaxRationalBits,
+	})
 }
 
-func submitResult(taskID string, result float64) error {
+// submitResult отправляет результат задачи или, если code не пуст, код ошибки вместо него
+func submitResult(ctx context.Context, agentID, taskID string, result models.Value, code string) (err error) {
+	ctx, span := tracer().Start(ctx, "submit result", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	payload := struct {
-		ID     string  `json:"id"`
-		Result float64 `json:"result"`
+		ID        string        `json:"id"`
+		Result    *models.Value `json:"result,omitempty"`
+		ErrorCode string        `json:"error_code,omitempty"`
 	}{
-		ID:     taskID,
-		Result: result,
+		ID:        taskID,
+		ErrorCode: code,
+	}
+	if code == "" {
+		payload.Result = &result
 	}
 
 	jsonData, _ := json.Marshal(payload)
-	resp, err := http.Post(
-		"http://localhost:8080/internal/task",
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, orchestratorURL+"/internal/task", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Agent-ID", agentID)
+	setHeaders(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("post failed: %w", err)
 	}
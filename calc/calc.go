@@ -0,0 +1,203 @@
+package calc
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// Task — то, что нужно для вычисления одной операции: аргументы и режимы задачи
+type Task struct {
+	Operation string
+	Arg1      Value
+	Arg2      Value
+	Numeric   string // NumericFloat, NumericRational или NumericComplex
+	NonFinite string // NonFiniteError или NonFiniteIEEE
+
+	// MaxRationalBits — предельный размер числителя и знаменателя дроби в битах;
+	// 0 — DefaultMaxRationalBits
+	MaxRationalBits int
+}
+
+// DefaultMaxRationalBits ограничивает дроби по умолчанию. Без предела несколько
+// возведений в квадрат дают числа в мегабайты, и каждое следующее умножение дороже.
+const DefaultMaxRationalBits = 16384
+
+// comparisons — операции сравнения; их результат логический
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// Calculate вычисляет задачу. Вместо результата, который нельзя вернуть,
+// возвращается код ошибки: деление на ноль, отсутствующий аргумент, слишком
+// большая дробь, а в режиме NonFiniteError ещё и бесконечность или NaN.
+func Calculate(task Task) (Value, string) {
+	if comparisons[task.Operation] || task.Operation == "!" {
+		return Bool(calculateLogical(task.Operation, task.Arg1, task.Arg2)), ""
+	}
+
+	var result Value
+	switch task.Numeric {
+	case NumericRational:
+		arg1, ok1 := toRat(task.Arg1)
+		arg2, ok2 := toRat(task.Arg2)
+		if !ok1 || !ok2 {
+			return Value{}, ErrorInvalidArgument
+		}
+		if task.Operation == "/" && arg2.Sign() == 0 {
+			// У дробей нет бесконечности — это ошибка в любом режиме
+			return Value{}, ErrorDivisionByZero
+		}
+		r := Rational(calculateRational(task.Operation, arg1, arg2))
+		if TooLarge(r, task.MaxRationalBits) {
+			return Value{}, ErrorTooLarge
+		}
+		return r, ""
+	case NumericComplex:
+		result = Complex(calculateComplex(task.Operation, task.Arg1.Complex128(), task.Arg2.Complex128()))
+	default:
+		result = Float(calculateFloat(task.Operation, task.Arg1.Float64(), task.Arg2.Float64()))
+	}
+
+	if task.NonFinite == NonFiniteIEEE {
+		return result, ""
+	}
+	if task.Operation == "/" && task.Arg2.Complex128() == 0 {
+		return Value{}, ErrorDivisionByZero
+	}
+	return result, NonFiniteCode(result)
+}
+
+// NonFiniteCode возвращает код ошибки для бесконечного или NaN результата
+// и пустую строку для конечного
+func NonFiniteCode(v Value) string {
+	switch {
+	case v.IsFinite():
+		return ""
+	case math.IsNaN(real(v.Complex128())) || math.IsNaN(imag(v.Complex128())):
+		return ErrorNaN
+	default:
+		return ErrorOverflow
+	}
+}
+
+// TooLarge сообщает, что числитель или знаменатель дроби длиннее maxBits бит
+// (0 — DefaultMaxRationalBits). Для остальных значений всегда false.
+func TooLarge(v Value, maxBits int) bool {
+	if !v.IsRational() {
+		return false
+	}
+	if maxBits <= 0 {
+		maxBits = DefaultMaxRationalBits
+	}
+	return v.Rat.Num().BitLen() > maxBits || v.Rat.Denom().BitLen() > maxBits
+}
+
+// calculateLogical выполняет сравнение или логическое отрицание
+func calculateLogical(op string, arg1, arg2 Value) bool {
+	if op == "!" {
+		return !arg1.Bool
+	}
+
+	var cmp int
+	switch {
+	case arg1.IsBool():
+		return (arg1.Bool == arg2.Bool) == (op == "==")
+	case arg1.Numeric() == NumericComplex:
+		return (arg1.Complex == arg2.Complex) == (op == "==")
+	case arg1.IsRational() && arg2.IsRational():
+		cmp = arg1.Rat.Cmp(arg2.Rat)
+	default:
+		a, b := arg1.Float64(), arg2.Float64()
+		if math.IsNaN(a) || math.IsNaN(b) {
+			// С NaN ложны все сравнения, кроме !=
+			return op == "!="
+		}
+		if a < b {
+			cmp = -1
+		} else if a > b {
+			cmp = 1
+		}
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func calculateFloat(op string, arg1, arg2 float64) float64 {
+	switch op {
+	case "+":
+		return arg1 + arg2
+	case "-":
+		return arg1 - arg2
+	case "*":
+		return arg1 * arg2
+	case "/":
+		return arg1 / arg2
+	case "sqrt":
+		return math.Sqrt(arg1)
+	default:
+		return arg1
+	}
+}
+
+// calculateComplex выполняет операцию над комплексными числами
+func calculateComplex(op string, arg1, arg2 complex128) complex128 {
+	switch op {
+	case "+":
+		return arg1 + arg2
+	case "-":
+		return arg1 - arg2
+	case "*":
+		return arg1 * arg2
+	case "/":
+		return arg1 / arg2
+	case "sqrt":
+		return cmplx.Sqrt(arg1)
+	default:
+		return arg1
+	}
+}
+
+// calculateRational выполняет операцию над точными дробями
+func calculateRational(op string, arg1, arg2 *big.Rat) *big.Rat {
+	result := new(big.Rat)
+	switch op {
+	case "+":
+		return result.Add(arg1, arg2)
+	case "-":
+		return result.Sub(arg1, arg2)
+	case "*":
+		return result.Mul(arg1, arg2)
+	case "/":
+		return result.Quo(arg1, arg2)
+	default:
+		return result.Set(arg1)
+	}
+}
+
+// toRat возвращает аргумент дробью. Конечное float-значение переводится
+// в дробь точно; пустой аргумент, дробь без значения и бесконечность — ошибка.
+func toRat(v Value) (*big.Rat, bool) {
+	switch v.Kind {
+	case NumericRational:
+		return v.Rat, v.Rat != nil
+	case NumericFloat:
+		if !isFinite(v.Float) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(v.Float), true
+	default:
+		return nil, false
+	}
+}
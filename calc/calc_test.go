@@ -0,0 +1,78 @@
+package calc
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rat(s string) Value {
+	r, _ := new(big.Rat).SetString(s)
+	return Rational(r)
+}
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name   string
+		task   Task
+		result Value
+		code   string
+	}{
+		{"float", Task{Operation: "*", Arg1: Float(1.5), Arg2: Float(4)}, Float(6), ""},
+		{"sqrt", Task{Operation: "sqrt", Arg1: Float(9)}, Float(3), ""},
+		{"rational", Task{Operation: "+", Arg1: rat("1/3"), Arg2: rat("1/6"), Numeric: NumericRational}, rat("1/2"), ""},
+		{"rational from float", Task{Operation: "-", Arg1: rat("1/2"), Arg2: Float(0.25), Numeric: NumericRational}, rat("1/4"), ""},
+		{"rational by zero", Task{Operation: "/", Arg1: rat("1"), Arg2: rat("0"), Numeric: NumericRational, NonFinite: NonFiniteIEEE}, Value{}, ErrorDivisionByZero},
+		{"complex", Task{Operation: "sqrt", Arg1: Complex(-4), Numeric: NumericComplex}, Complex(2i), ""},
+		{"comparison", Task{Operation: "<", Arg1: rat("1/3"), Arg2: rat("1/2")}, Bool(true), ""},
+		{"comparison with NaN", Task{Operation: "!=", Arg1: Float(math.NaN()), Arg2: Float(1)}, Bool(true), ""},
+		{"not", Task{Operation: "!", Arg1: Bool(true)}, Bool(false), ""},
+		{"division by zero", Task{Operation: "/", Arg1: Float(1), Arg2: Float(0)}, Value{}, ErrorDivisionByZero},
+		{"overflow", Task{Operation: "*", Arg1: Float(1e308), Arg2: Float(10)}, Value{}, ErrorOverflow},
+		{"nan", Task{Operation: "sqrt", Arg1: Float(-1)}, Value{}, ErrorNaN},
+		{"ieee", Task{Operation: "/", Arg1: Float(-1), Arg2: Float(0), NonFinite: NonFiniteIEEE}, Float(math.Inf(-1)), ""},
+	}
+	for _, tt := range tests {
+		result, code := Calculate(tt.task)
+		assert.Equal(t, tt.code, code, tt.name)
+		if code == "" {
+			assert.Equal(t, tt.result.String(), result.String(), tt.name)
+		}
+	}
+}
+
+func TestCalculateRationalSizeLimit(t *testing.T) {
+	v := rat("3/7")
+	for i := 0; i < 4; i++ {
+		var code string
+		v, code = Calculate(Task{Operation: "*", Arg1: v, Arg2: v, Numeric: NumericRational, MaxRationalBits: 64})
+		if !assert.Empty(t, code, "(3/7)^%d", 2<<i) {
+			return
+		}
+	}
+	assert.Equal(t, "43046721/33232930569601", v.String())
+	_, code := Calculate(Task{Operation: "*", Arg1: v, Arg2: v, Numeric: NumericRational, MaxRationalBits: 64})
+	assert.Equal(t, ErrorTooLarge, code)
+
+	// Без явного предела действует DefaultMaxRationalBits
+	_, code = Calculate(Task{Operation: "*", Arg1: v, Arg2: v, Numeric: NumericRational})
+	assert.Empty(t, code)
+	assert.True(t, TooLarge(Rational(new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), DefaultMaxRationalBits))), 0))
+	assert.False(t, TooLarge(Float(math.MaxFloat64), 1))
+}
+
+func TestCalculateInvalidRationalArgument(t *testing.T) {
+	for _, args := range [][2]Value{
+		{rat("1"), {}},
+		{{}, rat("1")},
+		{Rational(nil), rat("1")},
+		{rat("1"), Float(math.Inf(1))},
+	} {
+		for _, op := range []string{"+", "/"} {
+			_, code := Calculate(Task{Operation: op, Arg1: args[0], Arg2: args[1], Numeric: NumericRational})
+			assert.Equal(t, ErrorInvalidArgument, code, "%s %v", op, args)
+		}
+	}
+}
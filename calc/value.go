@@ -0,0 +1,241 @@
+// Package calc — значения задач и их вычисление, общие для оркестратора и агента:
+// оркестратор сворачивает константы теми же правилами, по которым считает агент.
+package calc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Числовые режимы вычисления выражения
+const (
+	NumericFloat    = "float"
+	NumericRational = "rational"
+	NumericComplex  = "complex"
+
+	// KindBool — логическое значение: результат сравнения, && или ||
+	KindBool = "bool"
+)
+
+// Режимы обработки нечисловых результатов (деление на ноль, переполнение, NaN)
+const (
+	NonFiniteError = "error" // задача завершается ошибкой с кодом
+	NonFiniteIEEE  = "ieee"  // результат ±Inf или NaN по IEEE 754
+)
+
+// Коды ошибок, которые агент передаёт вместо результата
+const (
+	ErrorDivisionByZero  = "division_by_zero"
+	ErrorOverflow        = "overflow"
+	ErrorNaN             = "nan"
+	ErrorInvalidArgument = "invalid_argument" // аргумент задачи отсутствует или не подходит её режиму
+	ErrorTooLarge        = "too_large"        // дробь длиннее MaxRationalBits
+)
+
+// Value — операнд или результат задачи.
+// В режиме float передаётся JSON-числом, в режиме rational — строкой "num/den",
+// в режиме complex — массивом [re, im], логические значения — true/false.
+// Бесконечности и NaN передаются строками "+Inf", "-Inf" и "NaN".
+type Value struct {
+	Kind    string
+	Float   float64
+	Rat     *big.Rat
+	Complex complex128
+	Bool    bool
+}
+
+// Float создаёт значение с плавающей точкой
+func Float(f float64) Value {
+	return Value{Kind: NumericFloat, Float: f}
+}
+
+// Rational создаёт точное рациональное значение
+func Rational(r *big.Rat) Value {
+	return Value{Kind: NumericRational, Rat: r}
+}
+
+// Complex создаёт комплексное значение
+func Complex(c complex128) Value {
+	return Value{Kind: NumericComplex, Complex: c}
+}
+
+// Bool создаёт логическое значение
+func Bool(b bool) Value {
+	return Value{Kind: KindBool, Bool: b}
+}
+
+// Numeric возвращает режим значения (или KindBool); пустое значение считается float
+func (v Value) Numeric() string {
+	if v.Kind == "" || v.Kind == NumericRational && v.Rat == nil {
+		return NumericFloat
+	}
+	return v.Kind
+}
+
+// IsBool сообщает, хранит ли значение логический результат
+func (v Value) IsBool() bool {
+	return v.Kind == KindBool
+}
+
+// IsRational сообщает, хранит ли значение точную дробь
+func (v Value) IsRational() bool {
+	return v.Numeric() == NumericRational
+}
+
+// Float64 возвращает приближение значения числом с плавающей точкой.
+// Для комплексного значения это его действительная часть, для логического — 1 или 0.
+func (v Value) Float64() float64 {
+	switch v.Numeric() {
+	case KindBool:
+		if v.Bool {
+			return 1
+		}
+		return 0
+	case NumericRational:
+		f, _ := v.Rat.Float64()
+		return f
+	case NumericComplex:
+		return real(v.Complex)
+	default:
+		return v.Float
+	}
+}
+
+// Complex128 возвращает значение как комплексное число
+func (v Value) Complex128() complex128 {
+	if v.Numeric() == NumericComplex {
+		return v.Complex
+	}
+	return complex(v.Float64(), 0)
+}
+
+// IsFinite сообщает, что значение не содержит бесконечностей и NaN
+func (v Value) IsFinite() bool {
+	switch v.Numeric() {
+	case NumericRational, KindBool:
+		return true
+	case NumericComplex:
+		return isFinite(real(v.Complex)) && isFinite(imag(v.Complex))
+	default:
+		return isFinite(v.Float)
+	}
+}
+
+func isFinite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+func (v Value) String() string {
+	switch v.Numeric() {
+	case NumericRational:
+		return v.Rat.String()
+	case NumericComplex:
+		s := strconv.FormatComplex(v.Complex, 'g', -1, 128)
+		return strings.TrimSuffix(strings.TrimPrefix(s, "("), ")")
+	case KindBool:
+		return strconv.FormatBool(v.Bool)
+	default:
+		return fmt.Sprint(v.Float)
+	}
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.Numeric() {
+	case NumericRational:
+		return json.Marshal(v.Rat.String())
+	case NumericComplex:
+		return json.Marshal([2]JSONFloat{JSONFloat(real(v.Complex)), JSONFloat(imag(v.Complex))})
+	case KindBool:
+		return json.Marshal(v.Bool)
+	default:
+		return json.Marshal(JSONFloat(v.Float))
+	}
+}
+
+// UnmarshalJSON определяет режим по типу JSON-значения:
+// строка — дробь (или ±Inf/NaN), массив [re, im] — комплексное число,
+// true/false — логическое значение, число — float
+func (v *Value) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "null":
+		return nil
+	case "true", "false":
+		*v = Bool(string(data) == "true")
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if f, ok := parseNonFinite(s); ok {
+			*v = Float(f)
+			return nil
+		}
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return fmt.Errorf("invalid rational value %q", s)
+		}
+		*v = Rational(r)
+		return nil
+	}
+
+	var pair []JSONFloat
+	if err := json.Unmarshal(data, &pair); err == nil {
+		if len(pair) != 2 {
+			return fmt.Errorf("complex value must be [re, im], got %s", data)
+		}
+		*v = Complex(complex(float64(pair[0]), float64(pair[1])))
+		return nil
+	}
+
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("invalid value: %s", data)
+	}
+	*v = Float(f)
+	return nil
+}
+
+// JSONFloat — float64, который кодирует ±Inf и NaN строками, а не ломает encoding/json
+type JSONFloat float64
+
+func (f JSONFloat) MarshalJSON() ([]byte, error) {
+	switch v := float64(f); {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func (f *JSONFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, ok := parseNonFinite(s)
+		if !ok {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		*f = JSONFloat(v)
+		return nil
+	}
+	return json.Unmarshal(data, (*float64)(f))
+}
+
+func parseNonFinite(s string) (float64, bool) {
+	switch s {
+	case "NaN":
+		return math.NaN(), true
+	case "+Inf", "Inf":
+		return math.Inf(1), true
+	case "-Inf":
+		return math.Inf(-1), true
+	}
+	return 0, false
+}
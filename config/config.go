@@ -4,6 +4,7 @@ package config
 import (
     "os"
     "strconv"
+    "time"
 )
 
 type Config struct {
@@ -11,6 +12,17 @@ type Config struct {
     SubtractionTime    int
     MultiplicationTime int
     DivisionTime       int
+    APIKeysFile        string        // файл ключей доступа оркестратора; пусто — анонимным открыт только /api/*
+    AuthDisabled       bool          // AUTH_DISABLED=true: анонимным открыто всё, включая /internal/* и /admin/*
+    JWTSecret          string        // ключ подписи токенов пользователей; пусто — случайный при каждом запуске
+    TokenTTL           time.Duration // срок действия токена пользователя
+    TLSCertFile        string        // сертификат оркестратора; вместе с TLSKeyFile включает HTTPS
+    TLSKeyFile         string
+    ClientCAFile       string        // CA сертификатов агентов; включает mTLS для /internal/*
+    RateLimit          float64       // запросов в секунду к /api/* от одного клиента; 0 — без ограничения
+    RateBurst          int           // сколько запросов клиент может отправить подряд
+    MaxBodyBytes       int64         // наибольший размер тела запроса к /api/*
+    MetricsAddr        string        // отдельный адрес для /metrics без ключа доступа; пусто — только основной с ролью admin
 }
 
 func Load() *Config {
@@ -19,6 +31,17 @@ func Load() *Config {
         SubtractionTime:    getEnvAsInt("TIME_SUBTRACTION_MS", 1000),
         MultiplicationTime: getEnvAsInt("TIME_MULTIPLICATIONS_MS", 1000),
         DivisionTime:       getEnvAsInt("TIME_DIVISIONS_MS", 1000),
+        APIKeysFile:        os.Getenv("API_KEYS_FILE"),
+        AuthDisabled:       os.Getenv("AUTH_DISABLED") == "true",
+        JWTSecret:          os.Getenv("JWT_SECRET"),
+        TokenTTL:           getEnvAsDuration("JWT_TTL", 24*time.Hour),
+        TLSCertFile:        os.Getenv("TLS_CERT_FILE"),
+        TLSKeyFile:         os.Getenv("TLS_KEY_FILE"),
+        ClientCAFile:       os.Getenv("TLS_CLIENT_CA_FILE"),
+        RateLimit:          getEnvAsFloat("RATE_LIMIT", 10),
+        RateBurst:          getEnvAsInt("RATE_BURST", 20),
+        MaxBodyBytes:       int64(getEnvAsInt("MAX_BODY_BYTES", 1<<20)),
+        MetricsAddr:        os.Getenv("METRICS_ADDR"),
     }
 }
 
@@ -29,4 +52,22 @@ func getEnvAsInt(key string, defaultValue int) int {
         }
     }
     return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+    if value, exists := os.LookupEnv(key); exists {
+        if f, err := strconv.ParseFloat(value, 64); err == nil && f >= 0 {
+            return f
+        }
+    }
+    return defaultValue
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+    if value, exists := os.LookupEnv(key); exists {
+        if d, err := time.ParseDuration(value); err == nil && d > 0 {
+            return d
+        }
+    }
+    return defaultValue
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "warn")
+	require.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	logger.InfoContext(ctx, "skipped")
+	logger.With("task_id", "t1").WarnContext(ctx, "task result rejected")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry), buf.String())
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, "task result rejected", entry["msg"])
+	assert.Equal(t, "t1", entry["task_id"])
+	assert.Equal(t, "req-1", entry["request_id"])
+
+	buf.Reset()
+	logger, err = New(&buf, "", "")
+	require.NoError(t, err)
+	logger.Debug("skipped")
+	logger.Info("expression created", "expression_id", "e1")
+	assert.Contains(t, buf.String(), "level=INFO msg=\"expression created\" expression_id=e1")
+	assert.NotContains(t, buf.String(), "skipped")
+	assert.NotContains(t, buf.String(), "request_id")
+
+	// Запись в контексте спана связывается с трассой
+	buf.Reset()
+	logger, err = New(&buf, "json", "")
+	require.NoError(t, err)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1, 2, 3},
+		SpanID:  trace.SpanID{4, 5, 6},
+	})
+	logger.InfoContext(trace.ContextWithSpanContext(context.Background(), sc), "task dispatched")
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry), buf.String())
+	assert.Equal(t, sc.TraceID().String(), entry["trace_id"])
+
+	_, err = New(&buf, "xml", "")
+	assert.Error(t, err)
+	_, err = New(&buf, "text", "verbose")
+	assert.Error(t, err)
+}
+
+func TestNewRequestID(t *testing.T) {
+	a, b := NewRequestID(), NewRequestID()
+	assert.Len(t, a, 16)
+	assert.NotEqual(t, a, b)
+	assert.Empty(t, RequestID(context.Background()))
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("http_requests_total", "HTTP requests.", "route", "code")
+	requests.Inc("GET /a", "200")
+	requests.Add(2, "GET /a", "200")
+	requests.Inc(`say "hi"`+"\n", "500")
+	r.NewGauge("queue_depth", "Tasks waiting\nfor agents.").Set(3)
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	latency.Observe(0.1, "+")
+	latency.Observe(0.5, "+")
+	latency.Observe(7, "+")
+	r.NewGaugeFunc("expressions", "Expressions by status.", []string{"status"}, func() []Sample {
+		return []Sample{{[]string{"completed"}, 2}, {[]string{"error"}, 0}}
+	})
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP http_requests_total HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{route="GET /a",code="200"} 3
+http_requests_total{route="say \"hi\"\n",code="500"} 1
+# HELP queue_depth Tasks waiting\nfor agents.
+# TYPE queue_depth gauge
+queue_depth 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="+",le="0.1"} 1
+latency_seconds_bucket{op="+",le="1"} 2
+latency_seconds_bucket{op="+",le="+Inf"} 3
+latency_seconds_sum{op="+"} 7.6
+latency_seconds_count{op="+"} 3
+# HELP expressions Expressions by status.
+# TYPE expressions gauge
+expressions{status="completed"} 2
+expressions{status="error"} 0
+`, b.String())
+}
+
+func TestHandler(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	a.NewCounter("a_total", "A.").Inc()
+	b.NewCounter("b_total", "B.")
+
+	rec := httptest.NewRecorder()
+	Handler(a, b).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	assert.Contains(t, rec.Body.String(), "a_total 1\n")
+	assert.Contains(t, rec.Body.String(), "# TYPE b_total counter\n")
+}
+
+func TestMisuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("c_total", "C.", "op")
+	assert.Panics(t, func() { r.NewGauge("c_total", "again") })
+	assert.Panics(t, func() { c.Inc() }, "missing label value")
+	assert.Panics(t, func() { c.Add(-1, "+") })
+	assert.Panics(t, func() { r.NewHistogram("h", "H.", []float64{1, 0.5}) })
+}
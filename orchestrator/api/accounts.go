@@ -0,0 +1,184 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+var (
+	ErrLoginTaken         = errors.New("login is already taken")
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	ErrInvalidToken       = errors.New("invalid token")
+)
+
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 72 // bcrypt учитывает только первые 72 байта
+	tokenIssuer    = "arithmetic-service"
+)
+
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,64}$`)
+
+// Accounts — учётные записи пользователей и выпуск их токенов (JWT, HS256)
+type Accounts struct {
+	secret   []byte
+	tokenTTL time.Duration
+	cost     int // стоимость bcrypt
+
+	mu      sync.RWMutex
+	byLogin map[string]models.User
+	dummy   []byte // хэш для сравнения, когда логин не найден: время ответа не выдаёт, есть ли пользователь
+}
+
+func NewAccounts(secret []byte, tokenTTL time.Duration) *Accounts {
+	return newAccounts(secret, tokenTTL, bcrypt.DefaultCost)
+}
+
+func newAccounts(secret []byte, tokenTTL time.Duration, cost int) *Accounts {
+	dummy, err := bcrypt.GenerateFromPassword([]byte("dummy password"), cost)
+	if err != nil {
+		panic(err) // возможно только при недопустимой стоимости
+	}
+	return &Accounts{secret: secret, tokenTTL: tokenTTL, cost: cost, byLogin: make(map[string]models.User), dummy: dummy}
+}
+
+// Register создаёт пользователя
+func (a *Accounts) Register(login, password string) (models.User, error) {
+	if !loginPattern.MatchString(login) {
+		return models.User{}, fmt.Errorf("login must be 3-64 letters, digits, '.', '_' or '-'")
+	}
+	if len(password) < minPasswordLen || len(password) > maxPasswordLen {
+		return models.User{}, fmt.Errorf("password must be %d-%d bytes long", minPasswordLen, maxPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), a.cost)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := a.byLogin[login]; exists {
+		return models.User{}, ErrLoginTaken
+	}
+	user := models.User{ID: randomID(), Login: login, PasswordHash: hash, CreatedAt: time.Now()}
+	a.byLogin[login] = user
+	return user, nil
+}
+
+// Login проверяет пароль и выпускает токен
+func (a *Accounts) Login(login, password string) (string, time.Time, error) {
+	a.mu.RLock()
+	user, exists := a.byLogin[login]
+	a.mu.RUnlock()
+
+	hash := user.PasswordHash
+	if !exists {
+		hash = a.dummy
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !exists {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+	return a.issue(user, time.Now())
+}
+
+// tokenClaims — содержимое токена: ID пользователя в sub и его логин
+type tokenClaims struct {
+	Login string `json:"login"`
+	jwt.RegisteredClaims
+}
+
+func (a *Accounts) issue(user models.User, now time.Time) (string, time.Time, error) {
+	expires := now.Add(a.tokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		Login: user.Login,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	})
+	signed, err := token.SignedString(a.secret)
+	return signed, expires, err
+}
+
+// Verify проверяет подпись и срок токена и возвращает ID и логин пользователя
+func (a *Accounts) Verify(raw string) (userID, login string, err error) {
+	var claims tokenClaims
+	_, err = jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (any, error) { return a.secret, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.Subject == "" {
+		return "", "", ErrInvalidToken
+	}
+	return claims.Subject, claims.Login, nil
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// credentials — тело запросов регистрации и входа
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// RegisterHandler — регистрация пользователя
+func (h *Handlers) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusUnprocessableEntity, "invalid request body")
+		return
+	}
+	user, err := h.accounts.Register(req.Login, req.Password)
+	switch {
+	case errors.Is(err, ErrLoginTaken):
+		h.respondError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		h.respondError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	h.respondJSON(w, http.StatusCreated, map[string]models.User{"user": user})
+}
+
+// LoginHandler — вход: в ответе токен для заголовка Authorization: Bearer
+func (h *Handlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusUnprocessableEntity, "invalid request body")
+		return
+	}
+	token, expires, err := h.accounts.Login(req.Login, req.Password)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		h.respondError(w, http.StatusUnauthorized, err.Error())
+		return
+	case err != nil:
+		h.respondError(w, http.StatusInternalServerError, "failed to issue token")
+		return
+	}
+	h.respondJSON(w, http.StatusOK, struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{token, expires})
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+)
+
+func TestAccounts(t *testing.T) {
+	accounts := newTestHandlers(nil).accounts
+
+	user, err := accounts.Register("alice", "correct horse")
+	require.NoError(t, err)
+	assert.NotEmpty(t, user.ID)
+	assert.NotContains(t, string(user.PasswordHash), "correct horse")
+
+	_, err = accounts.Register("alice", "another password")
+	assert.ErrorIs(t, err, ErrLoginTaken)
+	for _, bad := range []struct{ login, password string }{
+		{"al", "correct horse"},
+		{"alice smith", "correct horse"},
+		{"bob", "short"},
+		{"bob", strings.Repeat("x", 73)},
+	} {
+		_, err := accounts.Register(bad.login, bad.password)
+		assert.Error(t, err, bad.login)
+	}
+
+	token, expires, err := accounts.Login("alice", "correct horse")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+	id, login, err := accounts.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, id)
+	assert.Equal(t, "alice", login)
+
+	_, _, err = accounts.Login("alice", "wrong password")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, _, err = accounts.Login("nobody", "correct horse")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Истёкший токен, чужая подпись и другой алгоритм отклоняются
+	expired, _, err := accounts.issue(user, time.Now().Add(-2*time.Hour))
+	require.NoError(t, err)
+	_, _, err = accounts.Verify(expired)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	foreign, _, _ := newAccounts([]byte("other secret"), time.Hour, accounts.cost).issue(user, time.Now())
+	_, _, err = accounts.Verify(foreign)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Issuer: tokenIssuer, Subject: user.ID, ExpiresAt: jwt.NewNumericDate(expires)},
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, _, err = accounts.Verify(unsigned)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestExpressionOwnership(t *testing.T) {
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	keys, err := NewKeyStore([]APIKey{{Name: "ops", Role: RoleAdmin, SHA256: HashKey("admin-secret")}})
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/register", h.RegisterHandler)
+	mux.HandleFunc("POST /api/v1/login", h.LoginHandler)
+	mux.HandleFunc("/api/v1/calculate", h.CalculateHandler)
+	mux.HandleFunc("/api/v1/expressions", h.ExpressionsHandler)
+	mux.HandleFunc("/api/v1/expressions/", h.GetExpressionHandler)
+	mux.HandleFunc("GET /api/v1/expressions/{id}/tasks", h.ExpressionTasksHandler)
+	server := h.Authenticate(keys, AnonymousRole(keys, false), mux)
+
+	do := func(method, path string, header http.Header, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(name string) http.Header {
+		creds := `{"login": "` + name + `", "password": "password123"}`
+		require.Equal(t, http.StatusCreated, do("POST", "/api/v1/register", nil, creds).Code)
+		rec := do("POST", "/api/v1/login", nil, creds)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp struct{ Token string }
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return http.Header{"Authorization": {"Bearer " + resp.Token}}
+	}
+	create := func(header http.Header) string {
+		rec := do("POST", "/api/v1/calculate", header, `{"expression": "2 + 3 * 4", "optimize": false}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var resp struct{ ID string }
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.ID
+	}
+	list := func(header http.Header) []string {
+		rec := do("GET", "/api/v1/expressions", header, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp struct{ Expressions []models.Expression }
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		var ids []string
+		for _, e := range resp.Expressions {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+
+	alice, bob := login("alice"), login("bob")
+	admin := http.Header{"X-Api-Key": {"admin-secret"}}
+	aliceExpr, bobExpr := create(alice), create(bob)
+
+	assert.Equal(t, []string{aliceExpr}, list(alice))
+	assert.Equal(t, []string{bobExpr}, list(bob))
+	assert.ElementsMatch(t, []string{aliceExpr, bobExpr}, list(admin))
+
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/expressions/"+aliceExpr, alice, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/expressions/"+aliceExpr, bob, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/expressions/"+aliceExpr+"/tasks", bob, "").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/expressions/"+aliceExpr, admin, "").Code)
+
+	expr, _ := tm.GetExpressionByID(aliceExpr)
+	assert.Equal(t, "alice", expr.Submitter)
+	assert.NotEmpty(t, expr.Owner)
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/expressions", nil, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/expressions", http.Header{"Authorization": {"Bearer nope"}}, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/login", nil, `{"login": "alice", "password": "wrong"}`).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", "/api/v1/register", nil, `{"login": "alice", "password": "password123"}`).Code)
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+// Роли ключей доступа
+const (
+	RoleClient = "client" // /api/v1/*: выражения и их задачи
+	RoleAgent  = "agent"  // /internal/*: получение задач и отправка результатов
+	RoleAdmin  = "admin"  // все маршруты, включая /admin/*
+)
+
+var roles = map[string]bool{RoleClient: true, RoleAgent: true, RoleAdmin: true}
+
+// APIKey — ключ доступа из файла ключей. Сам ключ не хранится, только его SHA-256.
+type APIKey struct {
+	Name   string `json:"name"` // становится отправителем выражений, созданных с ключом
+	Role   string `json:"role"`
+	SHA256 string `json:"sha256"` // hex SHA-256 ключа
+}
+
+// KeyStore — ключи доступа по хэшу
+type KeyStore struct {
+	keys map[string]APIKey
+}
+
+// HashKey возвращает hex SHA-256 ключа — то, что записывается в файл ключей
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKeyStore проверяет ключи: роль должна быть известна, хэш — корректен,
+// а имена и хэши — не повторяться
+func NewKeyStore(keys []APIKey) (*KeyStore, error) {
+	store := &KeyStore{keys: make(map[string]APIKey, len(keys))}
+	names := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("api key without a name")
+		}
+		if !roles[key.Role] {
+			return nil, fmt.Errorf("api key %q: unknown role %q", key.Name, key.Role)
+		}
+		hash := strings.ToLower(key.SHA256)
+		if raw, err := hex.DecodeString(hash); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("api key %q: sha256 must be 64 hex digits", key.Name)
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("api key %q is listed twice", key.Name)
+		}
+		if _, dup := store.keys[hash]; dup {
+			return nil, fmt.Errorf("api key %q has the same hash as another key", key.Name)
+		}
+		names[key.Name] = true
+		key.SHA256 = hash
+		store.keys[hash] = key
+	}
+	return store, nil
+}
+
+// LoadKeys читает файл ключей вида {"keys": [{"name": ..., "role": ..., "sha256": ...}]}
+func LoadKeys(path string) (*KeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Keys []APIKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	store, err := NewKeyStore(file.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return store, nil
+}
+
+func (s *KeyStore) lookup(key string) (APIKey, bool) {
+	k, ok := s.keys[HashKey(key)]
+	return k, ok
+}
+
+// requiredRole — роль, которой открыт маршрут; пустая строка — маршрут открыт всем
+func requiredRole(path string) string {
+	switch {
+	case path == "/api/v1/register" || path == "/api/v1/login":
+		return ""
+	case strings.HasPrefix(path, "/internal/"):
+		return RoleAgent
+	case strings.HasPrefix(path, "/admin/"), path == "/metrics":
+		return RoleAdmin
+	case strings.HasPrefix(path, "/api/"):
+		return RoleClient
+	}
+	return ""
+}
+
+// caller — кто отправил запрос: владелец ключа доступа или пользователь
+type caller struct {
+	name  string // имя ключа или логин пользователя
+	role  string
+	owner string // владелец выражений: ID пользователя или "key:" и имя ключа
+}
+
+type callerKey struct{}
+
+// callerFrom возвращает отправителя запроса; ok == false для анонимного запроса
+func callerFrom(r *http.Request) (caller, bool) {
+	c, ok := r.Context().Value(callerKey{}).(caller)
+	return c, ok
+}
+
+// identify определяет отправителя по токену пользователя (Authorization: Bearer),
+// ключу доступа (X-API-Key) или сертификату агента. Без них возвращает nil.
+func (h *Handlers) identify(r *http.Request, keys *KeyStore) (*caller, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return nil, fmt.Errorf("authorization must be a bearer token")
+		}
+		userID, login, err := h.accounts.Verify(token)
+		if err != nil {
+			return nil, err
+		}
+		return &caller{name: login, role: RoleClient, owner: userID}, nil
+	}
+	if presented := r.Header.Get("X-API-Key"); presented != "" {
+		if keys == nil {
+			return nil, fmt.Errorf("invalid API key")
+		}
+		key, ok := keys.lookup(presented)
+		if !ok {
+			return nil, fmt.Errorf("invalid API key")
+		}
+		return &caller{name: key.Name, role: key.Role, owner: "key:" + key.Name}, nil
+	}
+	if cn := clientCertCN(r); cn != "" {
+		return &caller{name: cn, role: RoleAgent}, nil
+	}
+	return nil, nil
+}
+
+// AnonymousRole — роль запросов без учётных данных. По умолчанию анонимным
+// открыт только /api/*, и то лишь без файла ключей; /internal/*, /admin/*
+// и /metrics — только при authDisabled (AUTH_DISABLED=true).
+func AnonymousRole(keys *KeyStore, authDisabled bool) string {
+	switch {
+	case authDisabled:
+		return RoleAdmin
+	case keys == nil:
+		return RoleClient
+	default:
+		return ""
+	}
+}
+
+// Authenticate пропускает запрос, только если его отправитель — пользователь
+// с токеном или ключ с ролью, которой открыт маршрут (администратору открыто всё).
+// Неверные учётные данные — 401, не та роль — 403. Запрос без учётных данных
+// пропускается как анонимный, если маршрут открыт роли anonymous (см. AnonymousRole),
+// иначе — 401.
+func (h *Handlers) Authenticate(keys *KeyStore, anonymous string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := requiredRole(r.URL.Path)
+		if role == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := h.identify(r, keys)
+		if err != nil {
+			h.respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if c == nil {
+			if anonymous == role || anonymous == RoleAdmin {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.respondError(w, http.StatusUnauthorized, "missing credentials")
+			return
+		}
+		if c.role != role && c.role != RoleAdmin {
+			h.respondError(w, http.StatusForbidden, fmt.Sprintf("role %q cannot access this endpoint", c.role))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, *c)))
+	})
+}
+
+// submitter — отправитель выражения: имя ключа или логин пользователя,
+// для анонимного запроса — заголовок X-Submitter
+func submitter(r *http.Request) string {
+	if c, ok := callerFrom(r); ok {
+		return c.name
+	}
+	return r.Header.Get("X-Submitter")
+}
+
+// ownerID — владелец выражений отправителя запроса; пустой у анонимных запросов
+func ownerID(r *http.Request) string {
+	c, _ := callerFrom(r)
+	return c.owner
+}
+
+// seesAll сообщает, видны ли отправителю запроса чужие выражения (только администратору)
+func seesAll(r *http.Request) bool {
+	c, _ := callerFrom(r)
+	return c.role == RoleAdmin
+}
+
+// visible сообщает, может ли отправитель запроса видеть выражение
+func visible(r *http.Request, expr models.Expression) bool {
+	return seesAll(r) || expr.Owner == ownerID(r)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+)
+
+func newTestHandlers(tm *task_manager.TaskManager) *Handlers {
+	return NewHandlers(tm, newAccounts([]byte("test secret"), time.Hour, bcrypt.MinCost))
+}
+
+func writeKeys(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadKeys(t *testing.T) {
+	path := writeKeys(t, `{"keys": [
+		{"name": "billing", "role": "client", "sha256": "`+HashKey("client-secret")+`"},
+		{"name": "agents", "role": "agent", "sha256": "`+strings.ToUpper(HashKey("agent-secret"))+`"}
+	]}`)
+	keys, err := LoadKeys(path)
+	require.NoError(t, err)
+
+	key, ok := keys.lookup("client-secret")
+	assert.True(t, ok)
+	assert.Equal(t, "billing", key.Name)
+	key, ok = keys.lookup("agent-secret")
+	assert.True(t, ok)
+	assert.Equal(t, RoleAgent, key.Role)
+	_, ok = keys.lookup(HashKey("client-secret"))
+	assert.False(t, ok, "the stored hash is not a key")
+
+	for _, bad := range []string{
+		`{"keys": [{"name": "x", "role": "root", "sha256": "` + HashKey("a") + `"}]}`,
+		`{"keys": [{"name": "x", "role": "client", "sha256": "abc"}]}`,
+		`{"keys": [{"role": "client", "sha256": "` + HashKey("a") + `"}]}`,
+		`{"keys": [{"name": "x", "role": "client", "sha256": "` + HashKey("a") + `"},
+		           {"name": "y", "role": "agent", "sha256": "` + HashKey("a") + `"}]}`,
+		`{"keys": [`,
+	} {
+		_, err := LoadKeys(writeKeys(t, bad))
+		assert.Error(t, err, bad)
+	}
+	_, err = LoadKeys(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys, err := NewKeyStore([]APIKey{
+		{Name: "billing", Role: RoleClient, SHA256: HashKey("client-secret")},
+		{Name: "agents", Role: RoleAgent, SHA256: HashKey("agent-secret")},
+		{Name: "ops", Role: RoleAdmin, SHA256: HashKey("admin-secret")},
+	})
+	require.NoError(t, err)
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/calculate", h.CalculateHandler)
+	mux.HandleFunc("/internal/task", h.GetTaskHandler)
+	mux.HandleFunc("GET /admin/webhooks/dead-letters", h.DeadLettersHandler)
+	mux.HandleFunc("GET /metrics", h.MetricsHandler)
+	server := h.Authenticate(keys, AnonymousRole(keys, false), mux)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		req.Header.Set("X-Submitter", "mallory")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		method, path, key string
+		status            int
+	}{
+		{"GET", "/internal/task", "", http.StatusUnauthorized},
+		{"GET", "/internal/task", "wrong", http.StatusUnauthorized},
+		{"GET", "/internal/task", "client-secret", http.StatusForbidden},
+		{"GET", "/internal/task", "agent-secret", http.StatusNotFound}, // пропущен: задач нет
+		{"GET", "/internal/task", "admin-secret", http.StatusNotFound},
+		{"GET", "/admin/webhooks/dead-letters", "agent-secret", http.StatusForbidden},
+		{"GET", "/admin/webhooks/dead-letters", "client-secret", http.StatusForbidden},
+		{"GET", "/admin/webhooks/dead-letters", "admin-secret", http.StatusOK},
+		{"POST", "/api/v1/calculate", "", http.StatusUnauthorized},
+		{"POST", "/api/v1/calculate", "agent-secret", http.StatusForbidden},
+		{"GET", "/metrics", "", http.StatusUnauthorized},
+		{"GET", "/metrics", "client-secret", http.StatusForbidden},
+		{"GET", "/metrics", "admin-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := do(tt.method, tt.path, tt.key, "")
+		assert.Equal(t, tt.status, rec.Code, "%s %s with %q", tt.method, tt.path, tt.key)
+		if tt.status == http.StatusUnauthorized || tt.status == http.StatusForbidden {
+			var body map[string]string
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.NotEmpty(t, body["error"])
+		}
+	}
+
+	// Отправителем становится имя ключа, а не заголовок X-Submitter
+	rec := do("POST", "/api/v1/calculate", "client-secret", `{"expression": "2 + 3"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	expressions := tm.GetAllExpressions()
+	require.Len(t, expressions, 1)
+	assert.Equal(t, "billing", expressions[0].Submitter)
+
+	// ID агента — имя ключа: заголовком X-Agent-ID ключ не выдаст себя за другого агента
+	_, err = tm.CreateExpression("2 + 3", task_manager.Options{NoOptimize: true})
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/internal/task", nil)
+	req.Header.Set("X-API-Key", "agent-secret")
+	req.Header.Set("X-Agent-ID", "spoofed")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got struct {
+		Task taskPayload `json:"task"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	task, _ := tm.GetTask(got.Task.ID)
+	assert.Equal(t, "agents", task.AgentID)
+}
+
+// Без файла ключей анонимным открыт только /api/*, а всё — лишь при AUTH_DISABLED
+func TestAnonymousAccess(t *testing.T) {
+	h := newTestHandlers(task_manager.NewTaskManager())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/calculate", h.CalculateHandler)
+	mux.HandleFunc("/internal/task", h.GetTaskHandler)
+	mux.HandleFunc("GET /admin/agents", h.AgentsHandler)
+	mux.HandleFunc("GET /metrics", h.MetricsHandler)
+
+	tests := []struct {
+		method, path string
+		body         string
+		locked, open int // статус без AUTH_DISABLED и с ним
+	}{
+		{"POST", "/api/v1/calculate", `{"expression": "2 + 3", "optimize": false}`, http.StatusCreated, http.StatusCreated},
+		{"GET", "/internal/task", "", http.StatusUnauthorized, http.StatusOK},
+		{"GET", "/admin/agents", "", http.StatusUnauthorized, http.StatusOK},
+		{"GET", "/metrics", "", http.StatusUnauthorized, http.StatusOK},
+	}
+	for _, disabled := range []bool{false, true} {
+		server := h.Authenticate(nil, AnonymousRole(nil, disabled), mux)
+		for _, tt := range tests {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			want := tt.locked
+			if disabled {
+				want = tt.open
+			}
+			assert.Equal(t, want, rec.Code, "%s %s, AUTH_DISABLED=%v", tt.method, tt.path, disabled)
+		}
+	}
+
+	keys, err := NewKeyStore([]APIKey{{Name: "ops", Role: RoleAdmin, SHA256: HashKey("admin-secret")}})
+	require.NoError(t, err)
+	assert.Equal(t, "", AnonymousRole(keys, false))
+	assert.Equal(t, RoleAdmin, AnonymousRole(keys, true))
+}
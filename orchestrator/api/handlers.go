@@ -1,96 +1,482 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 
 	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
 	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
 )
 
 type Handlers struct {
-    tm *task_manager.TaskManager
+    tm       *task_manager.TaskManager
+    accounts *Accounts
+    rejected limitCounters // отклонённые Limit и обработчиками запросы
+    metrics  *httpMetrics
+}
+
+func NewHandlers(tm *task_manager.TaskManager, accounts *Accounts) *Handlers {
+    h := &Handlers{tm: tm, accounts: accounts}
+    h.metrics = newHTTPMetrics(h)
+    return h
+}
+
+
+// calculateRequest — выражение и параметры его вычисления
+type calculateRequest struct {
+    Expression  string `json:"expression"`
+    Numeric     string `json:"numeric"`      // "float" (по умолчанию), "rational" или "complex"
+    Optimize    *bool  `json:"optimize"`     // false отключает свёртку констант и упрощения
+    Cache       *bool  `json:"cache"`        // false отключает общий кэш результатов
+    CallbackURL string `json:"callback_url"` // куда отправить выражение после завершения
 }
 
-func NewHandlers(tm *task_manager.TaskManager) *Handlers {
-    return &Handlers{tm: tm}
+func (req calculateRequest) options(r *http.Request) task_manager.Options {
+    return task_manager.Options{
+        Numeric:     req.Numeric,
+        Submitter:   submitter(r),
+        Owner:       ownerID(r),
+        NoOptimize:  req.Optimize != nil && !*req.Optimize,
+        NoCache:     req.Cache != nil && !*req.Cache,
+        CallbackURL: req.CallbackURL,
+    }
 }
 
+const (
+    maxWait              = time.Minute // наибольшее время ожидания результата в синхронном режиме
+    maxIdempotencyKeyLen = 255
+)
 
-// CalculateHandler — добавление нового выражения
+// CalculateHandler — добавление нового выражения.
+// С ?wait=5s запрос ждёт завершения выражения и возвращает его целиком (200),
+// а если оно не успело завершиться — только ID (202).
 func (h *Handlers) CalculateHandler(w http.ResponseWriter, r *http.Request) {
-    var req struct {
-        Expression string `json:"expression"`
+    var wait time.Duration
+    if s := r.URL.Query().Get("wait"); s != "" {
+        var err error
+        wait, err = time.ParseDuration(s)
+        if err != nil || wait <= 0 || wait > maxWait {
+            h.respondError(w, http.StatusBadRequest, fmt.Sprintf("wait must be a duration from 0 to %s, e.g. 5s", maxWait))
+            return
+        }
     }
 
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    body, err := io.ReadAll(r.Body)
+    if h.bodyTooLarge(w, err) {
+        return
+    }
+    if err != nil {
+        h.respondError(w, http.StatusBadRequest, "failed to read request body")
+        return
+    }
+    var req calculateRequest
+    if err := json.Unmarshal(body, &req); err != nil {
         h.respondError(w, http.StatusUnprocessableEntity, "invalid request body")
         return
     }
 
-    tasks, err := h.tm.ParseExpression(req.Expression)
+    opts := req.options(r)
+    if key := r.Header.Get("Idempotency-Key"); key != "" {
+        if len(key) > maxIdempotencyKeyLen {
+            h.respondError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d bytes", maxIdempotencyKeyLen))
+            return
+        }
+        hash := sha256.Sum256(body)
+        opts.IdempotencyKey, opts.RequestHash = key, hex.EncodeToString(hash[:])
+    }
+
+    expr, err := h.tm.CreateExpressionContext(r.Context(), req.Expression, opts)
     if err != nil {
-        h.respondError(w, http.StatusUnprocessableEntity, err.Error())
+        if errors.Is(err, task_manager.ErrOverloaded) {
+            h.overloaded(w)
+        }
+        h.respondError(w, h.createStatus(err), err.Error())
+        return
+    }
+
+    if wait == 0 {
+        h.respondJSON(w, http.StatusCreated, map[string]string{"id": expr.ID})
         return
     }
+    ctx, cancel := context.WithTimeout(r.Context(), wait)
+    defer cancel()
+    if expr, finished, _ := h.tm.WaitExpression(ctx, expr.ID); finished {
+        h.respondJSON(w, http.StatusOK, map[string]models.Expression{"expression": expr})
+        return
+    }
+    h.respondJSON(w, http.StatusAccepted, map[string]string{"id": expr.ID})
+}
 
-    exprID := h.tm.GenerateID()
-    h.tm.SaveExpression(exprID, tasks)
+// createStatus возвращает код ответа на ошибку создания выражения
+// и учитывает отклонённые ограничениями выражения
+func (h *Handlers) createStatus(err error) int {
+    switch {
+    case errors.Is(err, task_manager.ErrInvalidCallback):
+        return http.StatusBadRequest
+    case errors.Is(err, task_manager.ErrIdempotencyConflict):
+        return http.StatusConflict
+    case errors.Is(err, task_manager.ErrExpressionTooLarge):
+        h.rejected.expressionTooLarge.Add(1)
+        return http.StatusRequestEntityTooLarge
+    case errors.Is(err, task_manager.ErrOverloaded):
+        return http.StatusServiceUnavailable
+    default:
+        return http.StatusUnprocessableEntity
+    }
+}
+
+// maxBatchSize — наибольшее число выражений в одном пакете
+const maxBatchSize = 10000
+
+// batchItem — элемент пакета: строка с выражением или объект
+// с необязательным ключом клиента и параметрами вычисления
+type batchItem struct {
+    Key string `json:"key,omitempty"`
+    calculateRequest
+}
+
+func (item *batchItem) UnmarshalJSON(data []byte) error {
+    if len(data) > 0 && data[0] == '"' {
+        return json.Unmarshal(data, &item.Expression)
+    }
+    type plain batchItem
+    return json.Unmarshal(data, (*plain)(item))
+}
 
-    h.respondJSON(w, http.StatusCreated, map[string]string{"id": exprID})
+// batchResult — итог по одному элементу пакета: ID выражения или ошибка.
+// Status — код, который получил бы такой же одиночный запрос.
+type batchResult struct {
+    Key    string `json:"key,omitempty"`
+    Status int    `json:"status"`
+    ID     string `json:"id,omitempty"`
+    Error  string `json:"error,omitempty"`
 }
 
+// BatchCalculateHandler — добавление пакета выражений. Ответ содержит итог
+// по каждому элементу в порядке запроса; ошибка одного элемента
+// не мешает создать остальные. Если задачам пакета не хватает места
+// в очереди, пакет отклоняется целиком с кодом 503.
+func (h *Handlers) BatchCalculateHandler(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Expressions []batchItem `json:"expressions"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        if !h.bodyTooLarge(w, err) {
+            h.respondError(w, http.StatusUnprocessableEntity, "invalid request body")
+        }
+        return
+    }
+    if len(req.Expressions) == 0 || len(req.Expressions) > maxBatchSize {
+        h.respondError(w, http.StatusBadRequest, fmt.Sprintf("batch must contain from 1 to %d expressions", maxBatchSize))
+        return
+    }
+
+    results := make([]batchResult, len(req.Expressions))
+    items := make([]task_manager.BatchItem, 0, len(req.Expressions))
+    positions := make([]int, 0, len(req.Expressions)) // позиция в запросе для каждого элемента items
+    keys := make(map[string]bool)
+    for i, item := range req.Expressions {
+        results[i].Key = item.Key
+        if item.Key != "" {
+            if keys[item.Key] {
+                results[i].Status, results[i].Error = http.StatusBadRequest, "duplicate key"
+                continue
+            }
+            keys[item.Key] = true
+        }
+        items = append(items, task_manager.BatchItem{Expression: item.Expression, Options: item.options(r)})
+        positions = append(positions, i)
+    }
+
+    batch, err := h.tm.CreateExpressionsContext(r.Context(), items)
+    if err != nil {
+        // Единственная ошибка пакета целиком — ErrOverloaded
+        h.overloaded(w)
+        h.respondError(w, http.StatusServiceUnavailable, err.Error())
+        return
+    }
+    for j, created := range batch {
+        if created.Err != nil {
+            results[positions[j]].Status = h.createStatus(created.Err)
+            results[positions[j]].Error = created.Err.Error()
+        } else {
+            results[positions[j]].Status = http.StatusCreated
+            results[positions[j]].ID = created.Expression.ID
+        }
+    }
+    h.respondJSON(w, http.StatusOK, map[string][]batchResult{"results": results})
+}
+
+// ExpressionsHandler — список выражений.
+// Параметры: status, submitter, created_after и created_before (RFC 3339),
+// sort (created_at или completed_at), order (asc или desc, по умолчанию desc),
+// cursor и limit.
 func (h *Handlers) ExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-    expressions := h.tm.GetAllExpressions()
-    h.respondJSON(w, http.StatusOK, map[string][]models.Expression{
-        "expressions": expressions, // Ключ "expressions" в JSON
-    })
+    query := r.URL.Query()
+    filter := task_manager.ExpressionFilter{
+        Status:    query.Get("status"),
+        Submitter: query.Get("submitter"),
+        SortBy:    query.Get("sort"),
+        Cursor:    query.Get("cursor"),
+        Owner:     ownerID(r),
+        ByOwner:   !seesAll(r),
+    }
+    for _, bound := range []struct {
+        param string
+        value *time.Time
+    }{{"created_after", &filter.CreatedAfter}, {"created_before", &filter.CreatedBefore}} {
+        if s := query.Get(bound.param); s != "" {
+            t, err := time.Parse(time.RFC3339, s)
+            if err != nil {
+                h.respondError(w, http.StatusBadRequest, bound.param+" must be an RFC 3339 time")
+                return
+            }
+            *bound.value = t
+        }
+    }
+    switch query.Get("order") {
+    case "", "desc":
+        filter.Desc = true
+    case "asc":
+    default:
+        h.respondError(w, http.StatusBadRequest, "order must be asc or desc")
+        return
+    }
+    var err error
+    if filter.Limit, err = intParam(query.Get("limit"), defaultPageLimit, maxPageLimit); err != nil || filter.Limit == 0 {
+        h.respondError(w, http.StatusBadRequest, "invalid limit")
+        return
+    }
+
+    expressions, next, err := h.tm.ListExpressions(filter)
+    if err != nil {
+        h.respondError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    h.respondJSON(w, http.StatusOK, struct {
+        Expressions []models.Expression `json:"expressions"`
+        NextCursor  string              `json:"next_cursor,omitempty"` // нет, если это последняя страница
+    }{expressions, next})
 }
 
 // GetExpressionHandler — получение выражения по ID
 func (h *Handlers) GetExpressionHandler(w http.ResponseWriter, r *http.Request) {
     id := strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/")
     expr, exists := h.tm.GetExpressionByID(id)
-    if !exists {
+    if !exists || !visible(r, expr) {
         h.respondError(w, http.StatusNotFound, "expression not found")
         return
     }
     h.respondJSON(w, http.StatusOK, map[string]models.Expression{"expression": expr})
 }
 
+// GraphHandler — дерево разбора и граф задач выражения.
+// ?format=dot (или Accept: text/vnd.graphviz) возвращает граф в формате Graphviz.
+func (h *Handlers) GraphHandler(w http.ResponseWriter, r *http.Request) {
+    if !h.visibleID(r, r.PathValue("id")) {
+        h.respondError(w, http.StatusNotFound, "expression not found")
+        return
+    }
+    graph, exists := h.tm.ExpressionGraph(r.PathValue("id"))
+    if !exists {
+        h.respondError(w, http.StatusNotFound, "expression not found")
+        return
+    }
+
+    format := r.URL.Query().Get("format")
+    if format == "" && strings.Contains(r.Header.Get("Accept"), "text/vnd.graphviz") {
+        format = "dot"
+    }
+    switch format {
+    case "", "json":
+        h.respondJSON(w, http.StatusOK, map[string]models.Graph{"graph": graph})
+    case "dot":
+        w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+        w.WriteHeader(http.StatusOK)
+        if _, err := w.Write([]byte(graph.DOT())); err != nil {
+            slog.ErrorContext(r.Context(), "failed to write graph", "expression_id", r.PathValue("id"), "err", err)
+        }
+    default:
+        h.respondError(w, http.StatusBadRequest, "unknown format, expected json or dot")
+    }
+}
+
+const (
+    defaultPageLimit = 100
+    maxPageLimit     = 1000
+)
+
+// ExpressionTasksHandler — задачи выражения.
+// Параметры: status (через запятую), offset и limit.
+func (h *Handlers) ExpressionTasksHandler(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    filter := task_manager.TaskFilter{Limit: defaultPageLimit}
+    if s := query.Get("status"); s != "" {
+        filter.Statuses = strings.Split(s, ",")
+    }
+    var err error
+    if filter.Offset, err = intParam(query.Get("offset"), 0, math.MaxInt); err != nil {
+        h.respondError(w, http.StatusBadRequest, "invalid offset")
+        return
+    }
+    if filter.Limit, err = intParam(query.Get("limit"), defaultPageLimit, maxPageLimit); err != nil || filter.Limit == 0 {
+        h.respondError(w, http.StatusBadRequest, "invalid limit")
+        return
+    }
+
+    if !h.visibleID(r, r.PathValue("id")) {
+        h.respondError(w, http.StatusNotFound, task_manager.ErrExpressionNotFound.Error())
+        return
+    }
+    tasks, total, err := h.tm.ListTasks(r.PathValue("id"), filter)
+    switch {
+    case errors.Is(err, task_manager.ErrExpressionNotFound):
+        h.respondError(w, http.StatusNotFound, err.Error())
+        return
+    case err != nil:
+        h.respondError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    response := struct {
+        Tasks      []models.Task `json:"tasks"`
+        Total      int           `json:"total"`
+        NextOffset *int          `json:"next_offset,omitempty"` // нет, если это последняя страница
+    }{Tasks: tasks, Total: total}
+    if next := filter.Offset + len(tasks); next < total {
+        response.NextOffset = &next
+    }
+    h.respondJSON(w, http.StatusOK, response)
+}
+
+// TaskHandler — задача по ID
+func (h *Handlers) TaskHandler(w http.ResponseWriter, r *http.Request) {
+    task, exists := h.tm.GetTask(r.PathValue("taskId"))
+    if !exists || !h.visibleID(r, task.ExpressionID) {
+        h.respondError(w, http.StatusNotFound, "task not found")
+        return
+    }
+    h.respondJSON(w, http.StatusOK, map[string]models.Task{"task": task})
+}
+
+// visibleID сообщает, существует ли выражение и может ли отправитель запроса его видеть
+func (h *Handlers) visibleID(r *http.Request, id string) bool {
+    expr, exists := h.tm.GetExpressionByID(id)
+    return exists && visible(r, expr)
+}
+
+// AgentsHandler — итоги проверки результатов по агентам
+func (h *Handlers) AgentsHandler(w http.ResponseWriter, r *http.Request) {
+    h.respondJSON(w, http.StatusOK, map[string][]task_manager.AgentStats{"agents": h.tm.AgentStats()})
+}
+
+// ReleaseAgentHandler — вывод агента из карантина
+func (h *Handlers) ReleaseAgentHandler(w http.ResponseWriter, r *http.Request) {
+    if !h.tm.ReleaseAgent(r.PathValue("agent")) {
+        h.respondError(w, http.StatusNotFound, "agent is not quarantined")
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// DeadLettersHandler — уведомления, которые не удалось доставить на callback_url
+func (h *Handlers) DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+    h.respondJSON(w, http.StatusOK, map[string][]task_manager.WebhookDelivery{"dead_letters": h.tm.DeadLetters()})
+}
+
+// RetryDeadLetterHandler — повторная отправка недоставленного уведомления
+func (h *Handlers) RetryDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+    id := r.PathValue("id")
+    if err := h.tm.RetryDeadLetter(id); err != nil {
+        status := http.StatusNotFound
+        if errors.Is(err, task_manager.ErrWebhookQueueFull) {
+            status = http.StatusServiceUnavailable
+        }
+        h.respondError(w, status, err.Error())
+        return
+    }
+    h.respondJSON(w, http.StatusAccepted, map[string]string{"expression_id": id})
+}
+
+// intParam разбирает неотрицательный целый параметр запроса не больше max
+func intParam(s string, def, max int) (int, error) {
+    if s == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil || n < 0 || n > max {
+        return 0, fmt.Errorf("invalid value %q", s)
+    }
+    return n, nil
+}
+
+// taskPayload — задача в формате протокола /internal/task.
+// Аргументы в режиме rational передаются строками "num/den", в режиме complex — парами [re, im].
+// TraceContext — контекст трассы выражения (W3C traceparent): агент продолжает
+// трассу и возвращает её в заголовке traceparent вместе с результатом.
+type taskPayload struct {
+    ID              string            `json:"id"`
+    ExpressionID    string            `json:"expression_id"`
+    Arg1            models.Value      `json:"arg1"`
+    Arg2            models.Value      `json:"arg2"`
+    Operation       string            `json:"operation"`
+    OperationTime   int               `json:"operation_time"`
+    Numeric         string            `json:"numeric"`
+    NonFinite       string            `json:"non_finite"`
+    MaxRationalBits int               `json:"max_rational_bits,omitempty"` // только в режиме rational
+    TraceContext    map[string]string `json:"trace_context,omitempty"`
+}
+
 func (h *Handlers) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
-    task, exists := h.tm.GetNextTask()
+    if h.tm.IsQuarantined(agentID(r)) {
+        h.respondError(w, http.StatusForbidden, task_manager.ErrAgentQuarantined.Error())
+        return
+    }
+    task, exists := h.tm.GetNextTaskFor(agentID(r))
     if !exists {
         h.respondError(w, http.StatusNotFound, "no tasks available")
         return
     }
+    slog.DebugContext(r.Context(), "task dispatched", "task_id", task.ID, "expression_id", task.ExpressionID,
+        "agent_id", agentID(r), "operation", task.Operation)
 
     // Преобразуем задачу в требуемый формат ответа
     response := struct {
-        Task struct {
-            ID            string  `json:"id"`
-            Arg1          float64 `json:"arg1"`
-            Arg2          float64 `json:"arg2"`
-            Operation     string  `json:"operation"`
-            OperationTime int     `json:"operation_time"`
-        } `json:"task"`
+        Task taskPayload `json:"task"`
     }{
-        Task: struct {
-            ID            string  `json:"id"`
-            Arg1          float64 `json:"arg1"`
-            Arg2          float64 `json:"arg2"`
-            Operation     string  `json:"operation"`
-            OperationTime int     `json:"operation_time"`
-        }{
+        Task: taskPayload{
             ID:            task.ID,
+            ExpressionID:  task.ExpressionID,
             Arg1:          task.Arg1,
             Arg2:          task.Arg2,
             Operation:     task.Operation,
-            OperationTime: int(task.OperationTime.Milliseconds()),
+            OperationTime: task.GetOperationTimeMS(),
+            Numeric:       task.Numeric,
+            NonFinite:     task.NonFinite,
         },
     }
+    if task.Numeric == models.NumericRational {
+        response.Task.MaxRationalBits = task.MaxRationalBits
+    }
+    carrier := propagation.MapCarrier{}
+    otel.GetTextMapPropagator().Inject(h.tm.TaskContext(context.Background(), task.ID), carrier)
+    if len(carrier) > 0 {
+        response.Task.TraceContext = carrier
+    }
 
     h.respondJSON(w, http.StatusOK, response)
 }
@@ -98,8 +484,9 @@ func (h *Handlers) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
 // SubmitResultHandler — прием результата от агента
 func (h *Handlers) SubmitResultHandler(w http.ResponseWriter, r *http.Request) {
     var req struct {
-        ID     string  `json:"id"`
-        Result float64 `json:"result"`
+        ID        string       `json:"id"`
+        Result    models.Value `json:"result"`
+        ErrorCode string       `json:"error_code"` // division_by_zero, overflow или nan вместо результата
     }
 
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -107,7 +494,30 @@ func (h *Handlers) SubmitResultHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    h.tm.SaveTaskResult(req.ID, req.Result)
+    task, _ := h.tm.GetTask(req.ID)
+    logger := slog.With("task_id", req.ID, "expression_id", task.ExpressionID, "agent_id", agentID(r))
+    var err error
+    if req.ErrorCode != "" {
+        _, err = h.tm.SaveTaskErrorFrom(req.ID, agentID(r), req.ErrorCode)
+    } else {
+        _, err = h.tm.SaveTaskResultFrom(req.ID, agentID(r), req.Result)
+    }
+    if err != nil {
+        logger.WarnContext(r.Context(), "task result rejected", "err", err)
+        switch {
+        case errors.Is(err, task_manager.ErrTaskNotFound):
+            h.respondError(w, http.StatusNotFound, err.Error())
+        case errors.Is(err, task_manager.ErrTaskCompleted), errors.Is(err, task_manager.ErrTaskNotInProgress),
+            errors.Is(err, task_manager.ErrTaskNotAssigned):
+            h.respondError(w, http.StatusConflict, err.Error())
+        case errors.Is(err, task_manager.ErrAgentQuarantined):
+            h.respondError(w, http.StatusForbidden, err.Error())
+        default:
+            h.respondError(w, http.StatusUnprocessableEntity, err.Error())
+        }
+        return
+    }
+    logger.DebugContext(r.Context(), "task result accepted", "error_code", req.ErrorCode)
     w.WriteHeader(http.StatusOK)
 }
 
@@ -116,7 +526,7 @@ func (h *Handlers) respondJSON(w http.ResponseWriter, status int, data interface
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(status)
     if err := json.NewEncoder(w).Encode(data); err != nil {
-        log.Printf("JSON encode error: %v", err)
+        slog.Error("failed to encode response", "err", err)
     }
 }
 
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+)
+
+func TestCalculateInvalidCallback(t *testing.T) {
+	h := newTestHandlers(task_manager.NewTaskManager())
+	for _, callback := range []string{"http://10.0.0.1/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]:8080/", "ftp://example.com/"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1 + 2", "callback_url": "`+callback+`"}`))
+		rec := httptest.NewRecorder()
+		h.CalculateHandler(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, callback)
+		assert.Contains(t, rec.Body.String(), "invalid callback_url", callback)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(`{"expressions": [
+		{"expression": "1 + 2", "callback_url": "http://192.168.1.5/hook"},
+		"2 + 3"
+	]}`))
+	rec := httptest.NewRecorder()
+	h.BatchCalculateHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp struct{ Results []batchResult }
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 2)
+	assert.Equal(t, http.StatusBadRequest, resp.Results[0].Status)
+	assert.Contains(t, resp.Results[0].Error, "internal address")
+	assert.Equal(t, http.StatusCreated, resp.Results[1].Status)
+	assert.NotEmpty(t, resp.Results[1].ID)
+}
+
+func TestSubmitResultStatus(t *testing.T) {
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	submit := func(body string) int {
+		rec := httptest.NewRecorder()
+		h.SubmitResultHandler(rec, httptest.NewRequest(http.MethodPost, "/internal/task", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	expr, err := tm.CreateExpression("(1 + 2) * 3", task_manager.Options{NoOptimize: true})
+	require.NoError(t, err)
+	tasks, _, err := tm.ListTasks(expr.ID, task_manager.TaskFilter{})
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	sum, product := tasks[0], tasks[1]
+
+	assert.Equal(t, http.StatusConflict, submit(`{"id": "`+sum.ID+`", "result": 3}`), "pending task")
+	assert.Equal(t, http.StatusConflict, submit(`{"id": "`+product.ID+`", "result": 9}`), "waiting task")
+	assert.Equal(t, http.StatusNotFound, submit(`{"id": "missing", "result": 1}`))
+
+	task, ok := tm.GetNextTaskFor("a1")
+	require.True(t, ok)
+	require.Equal(t, sum.ID, task.ID)
+	assert.Equal(t, http.StatusUnprocessableEntity, submit(`{"id": "`+sum.ID+`", "result": "1/2"}`), "result of another numeric mode")
+	assert.Equal(t, http.StatusOK, submit(`{"id": "`+sum.ID+`", "result": 3}`))
+	assert.Equal(t, http.StatusConflict, submit(`{"id": "`+sum.ID+`", "result": 3}`), "completed task")
+}
+
+// getJSON выполняет GET и разбирает ответ в v, если код 200
+func getJSON(t *testing.T, handler http.Handler, target string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code == http.StatusOK && v != nil {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v), rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestExpressionsHandler(t *testing.T) {
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	handler := http.HandlerFunc(h.ExpressionsHandler)
+
+	var created []models.Expression
+	for _, c := range []struct {
+		input string
+		opts  task_manager.Options
+	}{
+		{"1 + 2", task_manager.Options{NoOptimize: true}},
+		{"2 * 3", task_manager.Options{}},
+		{"4 - 1", task_manager.Options{Submitter: "alice"}},
+	} {
+		expr, err := tm.CreateExpression(c.input, c.opts)
+		require.NoError(t, err)
+		created = append(created, expr)
+		time.Sleep(2 * time.Millisecond)
+	}
+	tm.CreateExpression("5 + 5", task_manager.Options{Owner: "bob"})
+	first, second, third := created[0].ID, created[1].ID, created[2].ID
+
+	type page struct {
+		Expressions []models.Expression `json:"expressions"`
+		NextCursor  string              `json:"next_cursor"`
+	}
+	list := func(query string) ([]string, string) {
+		t.Helper()
+		var p page
+		require.Equal(t, http.StatusOK, getJSON(t, handler, "/api/v1/expressions"+query, &p), query)
+		ids := []string{}
+		for _, expr := range p.Expressions {
+			ids = append(ids, expr.ID)
+		}
+		return ids, p.NextCursor
+	}
+	at := func(expr models.Expression) string { return url.QueryEscape(expr.CreatedAt.Format(time.RFC3339Nano)) }
+
+	ids, next := list("")
+	assert.Equal(t, []string{third, second, first}, ids, "newest first; another owner's expression is hidden")
+	assert.Empty(t, next)
+	ids, _ = list("?order=asc")
+	assert.Equal(t, []string{first, second, third}, ids)
+	ids, _ = list("?status=completed")
+	assert.Equal(t, []string{third, second}, ids)
+	ids, _ = list("?submitter=alice")
+	assert.Equal(t, []string{third}, ids)
+	ids, _ = list("?created_after=" + at(created[0]))
+	assert.Equal(t, []string{third, second}, ids)
+	ids, _ = list("?created_before=" + at(created[2]) + "&created_after=" + at(created[0]))
+	assert.Equal(t, []string{second}, ids)
+
+	// Курсор есть, только пока за страницей остались подходящие выражения
+	ids, next = list("?order=asc&limit=2")
+	assert.Equal(t, []string{first, second}, ids)
+	require.NotEmpty(t, next)
+	ids, next = list("?order=asc&limit=2&cursor=" + next)
+	assert.Equal(t, []string{third}, ids)
+	assert.Empty(t, next)
+	ids, next = list("?limit=3")
+	assert.Len(t, ids, 3)
+	assert.Empty(t, next)
+	ids, next = list("?status=completed&limit=2")
+	assert.Equal(t, []string{third, second}, ids)
+	assert.Empty(t, next, "no further completed expressions")
+
+	_, next = list("?limit=1")
+	for _, query := range []string{
+		"?order=sideways",
+		"?status=done",
+		"?sort=priority",
+		"?created_after=yesterday",
+		"?created_before=2025-13-01T00:00:00Z",
+		"?limit=0", "?limit=-1", "?limit=1001", "?limit=ten",
+		"?cursor=not-a-cursor",
+		"?sort=completed_at&cursor=" + next,
+	} {
+		assert.Equal(t, http.StatusBadRequest, getJSON(t, handler, "/api/v1/expressions"+query, nil), query)
+	}
+}
+
+func TestGraphHandler(t *testing.T) {
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/expressions/{id}/graph", h.GraphHandler)
+
+	expr, err := tm.CreateExpression("(1 + 2) * 3", task_manager.Options{NoOptimize: true})
+	require.NoError(t, err)
+	other, err := tm.CreateExpression("4 + 5", task_manager.Options{Owner: "bob"})
+	require.NoError(t, err)
+	path := "/api/v1/expressions/" + expr.ID + "/graph"
+
+	var resp struct{ Graph models.Graph }
+	require.Equal(t, http.StatusOK, getJSON(t, mux, path, &resp))
+	assert.Equal(t, expr.ID, resp.Graph.ExpressionID)
+	assert.Len(t, resp.Graph.Tasks, 2)
+	assert.NotEmpty(t, resp.Graph.AST)
+	assert.Equal(t, http.StatusOK, getJSON(t, mux, path+"?format=json", nil))
+
+	accept := httptest.NewRequest(http.MethodGet, path, nil)
+	accept.Header.Set("Accept", "text/vnd.graphviz")
+	for name, req := range map[string]*http.Request{
+		"format=dot": httptest.NewRequest(http.MethodGet, path+"?format=dot", nil),
+		"Accept":     accept,
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+		assert.Equal(t, "text/vnd.graphviz; charset=utf-8", rec.Header().Get("Content-Type"), name)
+		assert.True(t, strings.HasPrefix(rec.Body.String(), "digraph "), name)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, mux, path+"?format=svg", nil))
+	assert.Equal(t, http.StatusNotFound, getJSON(t, mux, "/api/v1/expressions/missing/graph", nil))
+	assert.Equal(t, http.StatusNotFound, getJSON(t, mux, "/api/v1/expressions/"+other.ID+"/graph", nil), "another owner's expression")
+}
+
+func TestExpressionTasksHandler(t *testing.T) {
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/expressions/{id}/tasks", h.ExpressionTasksHandler)
+	mux.HandleFunc("GET /api/v1/tasks/{taskId}", h.TaskHandler)
+
+	expr, err := tm.CreateExpression("(1 + 2) * (3 + 4)", task_manager.Options{NoOptimize: true})
+	require.NoError(t, err)
+	other, err := tm.CreateExpression("4 + 5", task_manager.Options{Owner: "bob", NoOptimize: true})
+	require.NoError(t, err)
+	started, ok := tm.GetNextTaskFor("a1")
+	require.True(t, ok)
+	path := "/api/v1/expressions/" + expr.ID + "/tasks"
+
+	type page struct {
+		Tasks      []models.Task `json:"tasks"`
+		Total      int           `json:"total"`
+		NextOffset *int          `json:"next_offset"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		var p page
+		require.Equal(t, http.StatusOK, getJSON(t, mux, path+query, &p), query)
+		return p
+	}
+	statuses := func(p page) []string {
+		var s []string
+		for _, task := range p.Tasks {
+			s = append(s, task.Status)
+		}
+		return s
+	}
+
+	p := list("")
+	assert.Equal(t, 3, p.Total)
+	assert.ElementsMatch(t, []string{"in_progress", "pending", "waiting"}, statuses(p))
+	assert.Nil(t, p.NextOffset)
+	p = list("?status=pending,waiting")
+	assert.Equal(t, 2, p.Total)
+	assert.ElementsMatch(t, []string{"pending", "waiting"}, statuses(p))
+	p = list("?status=in_progress")
+	require.Len(t, p.Tasks, 1)
+	assert.Equal(t, started.ID, p.Tasks[0].ID)
+
+	// next_offset есть, только пока за страницей остались задачи
+	p = list("?limit=2")
+	assert.Len(t, p.Tasks, 2)
+	require.NotNil(t, p.NextOffset)
+	assert.Equal(t, 2, *p.NextOffset)
+	p = list("?limit=2&offset=2")
+	assert.Len(t, p.Tasks, 1)
+	assert.Equal(t, 3, p.Total)
+	assert.Nil(t, p.NextOffset)
+	p = list("?offset=5")
+	assert.Empty(t, p.Tasks)
+	assert.Nil(t, p.NextOffset)
+
+	for _, query := range []string{
+		"?status=done", "?status=pending,done",
+		"?offset=-1", "?offset=99999999999999999999", "?offset=one",
+		"?limit=0", "?limit=-1", "?limit=1001",
+	} {
+		assert.Equal(t, http.StatusBadRequest, getJSON(t, mux, path+query, nil), query)
+	}
+	assert.Equal(t, http.StatusNotFound, getJSON(t, mux, "/api/v1/expressions/missing/tasks", nil))
+	assert.Equal(t, http.StatusNotFound, getJSON(t, mux, "/api/v1/expressions/"+other.ID+"/tasks", nil), "another owner's expression")
+
+	var resp struct{ Task models.Task }
+	require.Equal(t, http.StatusOK, getJSON(t, mux, "/api/v1/tasks/"+started.ID, &resp))
+	assert.Equal(t, started.ID, resp.Task.ID)
+	assert.Equal(t, expr.ID, resp.Task.ExpressionID)
+	otherTasks, _, err := tm.ListTasks(other.ID, task_manager.TaskFilter{})
+	require.NoError(t, err)
+	require.NotEmpty(t, otherTasks)
+	assert.Equal(t, http.StatusNotFound, getJSON(t, mux, "/api/v1/tasks/"+otherTasks[0].ID, nil), "another owner's task")
+	assert.Equal(t, http.StatusNotFound, getJSON(t, mux, "/api/v1/tasks/missing", nil))
+}
+
+func TestBatchCalculateHandler(t *testing.T) {
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.BatchCalculateHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post(`{"expressions": [
+		"1 + 2",
+		{"key": "k1", "expression": "1/3 + 1/6", "numeric": "rational"},
+		"2 + * 4",
+		{"key": "k1", "expression": "3 + 4"},
+		{"expression": "5 - 1"}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp struct{ Results []batchResult }
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 5)
+
+	// Итоги идут в порядке запроса: свёрнутые константы дают результат сразу
+	result := func(i int) models.Expression {
+		t.Helper()
+		require.Equal(t, http.StatusCreated, resp.Results[i].Status, i)
+		expr, ok := tm.GetExpressionByID(resp.Results[i].ID)
+		require.True(t, ok, i)
+		return expr
+	}
+	assert.Equal(t, 3.0, result(0).Result, "string item")
+	assert.Equal(t, "k1", resp.Results[1].Key)
+	assert.Equal(t, "1/2", result(1).Exact, "object item keeps its options")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Results[2].Status)
+	assert.NotEmpty(t, resp.Results[2].Error)
+	assert.Empty(t, resp.Results[2].ID)
+	assert.Equal(t, batchResult{Key: "k1", Status: http.StatusBadRequest, Error: "duplicate key"}, resp.Results[3])
+	assert.Equal(t, 4.0, result(4).Result, "object item without key")
+
+	tooMany := strings.TrimSuffix(strings.Repeat(`"1 + 1",`, maxBatchSize+1), ",")
+	for name, body := range map[string]string{
+		"empty":    `{"expressions": []}`,
+		"missing":  `{}`,
+		"too many": `{"expressions": [` + tooMany + `]}`,
+	} {
+		rec := post(body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+		assert.Contains(t, rec.Body.String(), "batch must contain", name)
+	}
+	assert.Equal(t, http.StatusUnprocessableEntity, post(`{"expressions": "1 + 2"}`).Code)
+}
+
+func TestCalculateWait(t *testing.T) {
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	calculate := func(query, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.CalculateHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/calculate"+query, strings.NewReader(body)))
+		return rec
+	}
+
+	// Агент выполняет задачу, пока запрос ждёт результата
+	go func() {
+		for {
+			if task, ok := tm.GetNextTaskFor("a1"); ok {
+				tm.SaveTaskResultFrom(task.ID, "a1", models.Float(5))
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	rec := calculate("?wait=5s", `{"expression": "2 + 3", "optimize": false}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp struct{ Expression models.Expression }
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "completed", resp.Expression.Status)
+	assert.Equal(t, 5.0, resp.Expression.Result)
+	assert.NotEmpty(t, resp.Expression.ID)
+
+	rec = calculate("?wait=10ms", `{"expression": "4 + 5", "optimize": false}`)
+	require.Equal(t, http.StatusAccepted, rec.Code, "no agent finishes the task in time")
+	var accepted map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &accepted))
+	expr, ok := tm.GetExpressionByID(accepted["id"])
+	require.True(t, ok)
+	assert.Equal(t, "processing", expr.Status)
+
+	for _, wait := range []string{"abc", "5", "0s", "-1s", "2m"} {
+		rec := calculate("?wait="+wait, `{"expression": "1 + 2"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, wait)
+		assert.Contains(t, rec.Body.String(), "wait must be", wait)
+	}
+}
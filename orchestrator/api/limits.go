@@ -0,0 +1,177 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+)
+
+// Limits — ограничения запросов к публичному API (/api/*).
+// Нулевое значение поля снимает ограничение.
+type Limits struct {
+	Rate         float64 // запросов в секунду от одного клиента
+	Burst        int     // сколько запросов клиент может отправить подряд
+	MaxBodyBytes int64
+}
+
+// LimitStats — сколько запросов отклонено и почему
+type LimitStats struct {
+	RateLimited        int64 `json:"rate_limited"`
+	BodyTooLarge       int64 `json:"body_too_large"`
+	ExpressionTooLarge int64 `json:"expression_too_large"`
+	Overloaded         int64 `json:"overloaded"`
+}
+
+type limitCounters struct {
+	rateLimited        atomic.Int64
+	bodyTooLarge       atomic.Int64
+	expressionTooLarge atomic.Int64
+	overloaded         atomic.Int64
+}
+
+// LimitStats возвращает счётчики отклонённых запросов
+func (h *Handlers) LimitStats() LimitStats {
+	return LimitStats{
+		RateLimited:        h.rejected.rateLimited.Load(),
+		BodyTooLarge:       h.rejected.bodyTooLarge.Load(),
+		ExpressionTooLarge: h.rejected.expressionTooLarge.Load(),
+		Overloaded:         h.rejected.overloaded.Load(),
+	}
+}
+
+// LimitsHandler — счётчики отклонённых запросов и состояние очереди задач
+func (h *Handlers) LimitsHandler(w http.ResponseWriter, r *http.Request) {
+	h.respondJSON(w, http.StatusOK, struct {
+		Rejected  LimitStats                  `json:"rejected"`
+		Admission task_manager.AdmissionStats `json:"admission"`
+	}{h.LimitStats(), h.tm.Admission()})
+}
+
+// overloaded считает выражение, отклонённое из-за переполненной очереди,
+// и сообщает клиенту, когда повторить запрос. Статус ответа выбирает вызывающий.
+func (h *Handlers) overloaded(w http.ResponseWriter) {
+	h.rejected.overloaded.Add(1)
+	w.Header().Set("Retry-After", retryAfter(h.tm.RetryAfter()))
+}
+
+// retryAfter — значение заголовка Retry-After: целое число секунд, не меньше 1
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(d.Seconds())), 1))
+}
+
+// Limit ограничивает частоту и размер запросов к /api/*. Частота считается
+// по владельцу выражений (пользователю или ключу), у анонимных запросов —
+// по IP-адресу, поэтому Limit ставится внутри Authenticate. Превышение
+// частоты — 429 с Retry-After, слишком большое тело — 413.
+func (h *Handlers) Limit(limits Limits, next http.Handler) http.Handler {
+	var buckets *rateLimiter
+	if limits.Rate > 0 {
+		buckets = newRateLimiter(limits.Rate, max(limits.Burst, 1))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if buckets != nil {
+			if wait, ok := buckets.allow(clientKey(r), time.Now()); !ok {
+				h.rejected.rateLimited.Add(1)
+				w.Header().Set("Retry-After", retryAfter(wait))
+				h.respondError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+		}
+		if limits.MaxBodyBytes > 0 {
+			if r.ContentLength > limits.MaxBodyBytes {
+				h.bodyTooLarge(w, &http.MaxBytesError{Limit: limits.MaxBodyBytes})
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge отвечает 413, если чтение тела запроса упёрлось в MaxBodyBytes
+func (h *Handlers) bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	h.rejected.bodyTooLarge.Add(1)
+	h.respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+	return true
+}
+
+// clientKey — по кому считается частота запросов
+func clientKey(r *http.Request) string {
+	if owner := ownerID(r); owner != "" {
+		return owner
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimiter — корзины токенов по клиентам: корзина вмещает burst
+// токенов и пополняется на rate токенов в секунду, запрос забирает один
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow забирает токен из корзины клиента. Если корзина пуста,
+// возвращает, через сколько в ней появится токен.
+func (l *rateLimiter) allow(key string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > time.Minute {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+}
+
+// sweep удаляет уже полные корзины: они ничем не отличаются от новых
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		_, ok := l.allow("a", now)
+		assert.True(t, ok, "request %d fits into the burst", i)
+	}
+	wait, ok := l.allow("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	_, ok = l.allow("b", now)
+	assert.True(t, ok, "clients have separate buckets")
+
+	_, ok = l.allow("a", now.Add(500*time.Millisecond))
+	assert.True(t, ok, "one token is refilled in 1/rate seconds")
+	_, ok = l.allow("a", now.Add(500*time.Millisecond))
+	assert.False(t, ok)
+
+	l.allow("c", now.Add(2*time.Minute))
+	assert.Len(t, l.buckets, 1, "full buckets are swept")
+}
+
+func TestLimit(t *testing.T) {
+	keys, err := NewKeyStore([]APIKey{
+		{Name: "billing", Role: RoleClient, SHA256: HashKey("client-secret")},
+		{Name: "ops", Role: RoleAdmin, SHA256: HashKey("admin-secret")},
+	})
+	require.NoError(t, err)
+	h := newTestHandlers(task_manager.NewTaskManager())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/calculate", h.CalculateHandler)
+	mux.HandleFunc("POST /api/v1/calculate/batch", h.BatchCalculateHandler)
+	mux.HandleFunc("GET /admin/limits", h.LimitsHandler)
+	server := h.Authenticate(keys, AnonymousRole(keys, false), h.Limit(Limits{Rate: 1, Burst: 2, MaxBodyBytes: 64}, mux))
+
+	do := func(path, key, body string) *httptest.ResponseRecorder {
+		method := http.MethodPost
+		if body == "" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, do("/api/v1/calculate", "client-secret", `{"expression": "2 + 3"}`).Code)
+
+	rec := do("/api/v1/calculate", "client-secret", `{"expression": "`+strings.Repeat("1+", 40)+`1"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	rec = do("/api/v1/calculate", "client-secret", `{"expression": "2 + 3"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// Администратор — другой клиент, а /admin/* не ограничиваются
+	assert.Equal(t, http.StatusCreated, do("/api/v1/calculate", "admin-secret", `{"expression": "2 + 3"}`).Code)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, do("/admin/limits", "admin-secret", "").Code)
+	}
+	var got struct{ Rejected LimitStats }
+	require.NoError(t, json.Unmarshal(do("/admin/limits", "admin-secret", "").Body.Bytes(), &got))
+	assert.Equal(t, LimitStats{RateLimited: 1, BodyTooLarge: 1}, got.Rejected)
+}
+
+func TestBodyTooLargeWithoutContentLength(t *testing.T) {
+	h := newTestHandlers(task_manager.NewTaskManager())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/calculate", h.CalculateHandler)
+	mux.HandleFunc("POST /api/v1/calculate/batch", h.BatchCalculateHandler)
+	server := h.Limit(Limits{MaxBodyBytes: 32}, mux)
+
+	for _, path := range []string{"/api/v1/calculate", "/api/v1/calculate/batch"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"expression": "`+strings.Repeat("1+", 40)+`1"}`))
+		req.ContentLength = -1 // тело передаётся по частям, размер заранее неизвестен
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, path)
+	}
+	assert.Equal(t, int64(2), h.LimitStats().BodyTooLarge)
+}
+
+func TestExpressionTooLarge(t *testing.T) {
+	t.Setenv("MAX_EXPRESSION_TOKENS", "5")
+	h := newTestHandlers(task_manager.NewTaskManager())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1 + 2 + 3 + 4"}`))
+	rec := httptest.NewRecorder()
+	h.CalculateHandler(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Contains(t, rec.Body.String(), "7 tokens, at most 5")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(`{"expressions": ["1 + 2", "1 + 2 + 3 + 4"]}`))
+	rec = httptest.NewRecorder()
+	h.BatchCalculateHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(2), h.LimitStats().ExpressionTooLarge)
+}
+
+func TestOverloaded(t *testing.T) {
+	t.Setenv("MAX_PENDING_TASKS", "1")
+	t.Setenv("OVERLOAD_RETRY_AFTER", "1500ms")
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+
+	calculate := func(expr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "`+expr+`", "optimize": false}`))
+		rec := httptest.NewRecorder()
+		h.CalculateHandler(rec, req)
+		return rec
+	}
+	require.Equal(t, http.StatusCreated, calculate("1 + 2").Code)
+	rec := calculate("3 + 4")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(`{"expressions": ["2", {"expression": "2 * 3", "optimize": false}]}`))
+	rec = httptest.NewRecorder()
+	h.BatchCalculateHandler(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "a batch is rejected as a whole")
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "overloaded")
+	assert.Len(t, tm.GetAllExpressions(), 1)
+
+	// Пакет без задач помещается всегда, и Retry-After в нём нет
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(`{"expressions": ["2 * 3", "2 +"]}`))
+	rec = httptest.NewRecorder()
+	h.BatchCalculateHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+
+	rec = httptest.NewRecorder()
+	h.LimitsHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/limits", nil))
+	var got struct {
+		Rejected  LimitStats
+		Admission task_manager.AdmissionStats
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, LimitStats{Overloaded: 2}, got.Rejected)
+	assert.Equal(t, task_manager.AdmissionStats{Backlog: 1, Capacity: 1}, got.Admission)
+}
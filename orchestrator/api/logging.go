@@ -0,0 +1,44 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/m1tka051209/arithmetic-service/logging"
+)
+
+// requestIDPattern — какие ID запроса клиента принимаются как есть
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID присваивает запросу ID: берёт его из X-Request-ID (так агент
+// связывает свои записи журнала с записями оркестратора) или создаёт новый.
+// ID возвращается в ответе и попадает во все записи, сделанные с контекстом
+// запроса. После ответа запрос записывается в журнал; частые запросы агентов
+// и сборщика метрик — только на уровне debug.
+func (h *Handlers) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		ctx := logging.WithRequestID(r.Context(), id)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case strings.HasPrefix(r.URL.Path, "/internal/") || r.URL.Path == "/metrics":
+			level = slog.LevelDebug
+		}
+		slog.Log(ctx, level, "http request", "method", r.Method, "path", r.URL.Path,
+			"status", rec.status, "duration", time.Since(start))
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m1tka051209/arithmetic-service/logging"
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+)
+
+func TestRequestIDLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "json", "debug")
+	require.NoError(t, err)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/internal/task", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.SubmitResultHandler(w, r)
+			return
+		}
+		h.GetTaskHandler(w, r)
+	})
+	server := h.RequestID(h.Authenticate(nil, AnonymousRole(nil, true), mux))
+
+	do := func(method, body, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/internal/task", strings.NewReader(body))
+		req.Header.Set("X-Agent-ID", "agent-1")
+		if requestID != "" {
+			req.Header.Set(logging.RequestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	expr, err := tm.CreateExpression("2 + 3", task_manager.Options{NoOptimize: true})
+	require.NoError(t, err)
+
+	// Корректный ID клиента сохраняется, некорректный заменяется новым
+	rec := do("GET", "", "fetch-1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "fetch-1", rec.Header().Get(logging.RequestIDHeader))
+	var payload struct {
+		Task taskPayload `json:"task"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&payload))
+	assert.Equal(t, expr.ID, payload.Task.ExpressionID)
+
+	rec = do("POST", `{"id": "`+payload.Task.ID+`", "result": 5}`, "bad id\n")
+	require.Equal(t, http.StatusOK, rec.Code)
+	submitID := rec.Header().Get(logging.RequestIDHeader)
+	assert.Len(t, submitID, 16)
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		entries = append(entries, entry)
+	}
+	find := func(msg string) map[string]any {
+		for _, entry := range entries {
+			if entry["msg"] == msg {
+				return entry
+			}
+		}
+		t.Fatalf("no %q in log:\n%s", msg, buf.String())
+		return nil
+	}
+
+	dispatched := find("task dispatched")
+	assert.Equal(t, "fetch-1", dispatched["request_id"])
+	assert.Equal(t, payload.Task.ID, dispatched["task_id"])
+	assert.Equal(t, expr.ID, dispatched["expression_id"])
+	assert.Equal(t, "agent-1", dispatched["agent_id"])
+
+	accepted := find("task result accepted")
+	assert.Equal(t, submitID, accepted["request_id"])
+	assert.Equal(t, payload.Task.ID, accepted["task_id"])
+	assert.Equal(t, expr.ID, accepted["expression_id"])
+
+	finished := find("expression finished")
+	assert.Equal(t, expr.ID, finished["expression_id"])
+
+	access := find("http request")
+	assert.Equal(t, "DEBUG", access["level"])
+	assert.Equal(t, "fetch-1", access["request_id"])
+	assert.EqualValues(t, http.StatusOK, access["status"])
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/m1tka051209/arithmetic-service/metrics"
+)
+
+// httpMetrics — метрики HTTP-запросов по маршрутам
+type httpMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.Counter
+	duration *metrics.Histogram
+}
+
+func newHTTPMetrics(h *Handlers) *httpMetrics {
+	r := metrics.NewRegistry()
+	m := &httpMetrics{
+		registry: r,
+		requests: r.NewCounter("arithmetic_http_requests_total",
+			"HTTP requests by route pattern, method and status code.", "route", "method", "code"),
+		duration: r.NewHistogram("arithmetic_http_request_duration_seconds",
+			"HTTP request latency by route pattern.", metrics.DefBuckets, "route"),
+	}
+	r.NewCounterFunc("arithmetic_requests_rejected_total", "Requests rejected by limits, by reason.", []string{"reason"}, func() []metrics.Sample {
+		s := h.LimitStats()
+		return []metrics.Sample{
+			{LabelValues: []string{"rate_limited"}, Value: float64(s.RateLimited)},
+			{LabelValues: []string{"body_too_large"}, Value: float64(s.BodyTooLarge)},
+			{LabelValues: []string{"expression_too_large"}, Value: float64(s.ExpressionTooLarge)},
+			{LabelValues: []string{"overloaded"}, Value: float64(s.Overloaded)},
+		}
+	})
+	return m
+}
+
+// MetricsHandler — метрики оркестратора в формате Prometheus
+func (h *Handlers) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.Handler(h.tm.Metrics(), h.metrics.registry).ServeHTTP(w, r)
+}
+
+// Instrument считает запросы и их длительность по шаблонам маршрутов mux,
+// а не по путям: иначе каждый ID выражения дал бы свой ряд. Ставится снаружи
+// Authenticate и Limit, чтобы учитывать и отклонённые ими запросы.
+func (h *Handlers) Instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		h.metrics.requests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		h.metrics.duration.Observe(time.Since(start).Seconds(), route)
+	})
+}
+
+// statusRecorder запоминает код ответа
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+)
+
+func TestMetrics(t *testing.T) {
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/calculate", h.CalculateHandler)
+	mux.HandleFunc("GET /api/v1/expressions/{id}/tasks", h.ExpressionTasksHandler)
+	mux.HandleFunc("GET /metrics", h.MetricsHandler)
+	server := h.Instrument(mux, h.Authenticate(nil, AnonymousRole(nil, true), mux))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/calculate", `{"expression": "(1 + 2) * (2 + 1)", "optimize": false}`).Code)
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/calculate", `{"expression": "1 / (2 - 2)", "optimize": false}`).Code)
+	do("GET", "/api/v1/expressions/abc/tasks", "")
+	do("GET", "/api/v1/expressions/def/tasks", "")
+	do("GET", "/nowhere", "")
+
+	for {
+		task, ok := tm.GetNextTaskFor("agent-1")
+		if !ok {
+			break
+		}
+		switch task.Operation {
+		case "+":
+			tm.SaveTaskResult(task.ID, models.Float(3))
+		case "-":
+			tm.SaveTaskResult(task.ID, models.Float(0))
+		case "*":
+			tm.SaveTaskResult(task.ID, models.Float(9))
+		case "/":
+			tm.SaveTaskError(task.ID, models.ErrorDivisionByZero)
+		}
+	}
+
+	rec := do("GET", "/metrics", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	for _, line := range []string{
+		`arithmetic_expressions{status="completed"} 1`,
+		`arithmetic_expressions{status="error"} 1`,
+		`arithmetic_expressions{status="processing"} 0`,
+		`arithmetic_task_queue_depth 0`,
+		`arithmetic_task_backlog 0`,
+		`arithmetic_active_agents 1`,
+		`arithmetic_tasks_dispatched_total{operation="+"} 1`,
+		`arithmetic_tasks_finished_total{operation="*",outcome="completed"} 1`,
+		`arithmetic_tasks_finished_total{operation="/",outcome="error"} 1`,
+		`arithmetic_task_duration_seconds_count{operation="-"} 1`,
+		`# TYPE arithmetic_task_lease_expirations_total counter`,
+		`arithmetic_tasks_saved_total 1`,
+		`arithmetic_cache_hits_total 0`,
+		`arithmetic_cache_misses_total 4`,
+		`arithmetic_cache_entries 3`,
+		`arithmetic_http_requests_total{route="/api/v1/calculate",method="POST",code="201"} 2`,
+		`arithmetic_http_requests_total{route="GET /api/v1/expressions/{id}/tasks",method="GET",code="404"} 2`,
+		`arithmetic_http_requests_total{route="unmatched",method="GET",code="404"} 1`,
+		`arithmetic_http_request_duration_seconds_count{route="/api/v1/calculate"} 2`,
+		`arithmetic_requests_rejected_total{reason="overloaded"} 0`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
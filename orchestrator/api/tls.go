@@ -0,0 +1,75 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ServerTLSConfig — настройки TLS оркестратора. Если задан clientCAFile,
+// сервер запрашивает у клиентов сертификат и проверяет его по этому CA;
+// без сертификата подключиться можно, но /internal/* будут недоступны
+// (см. RequireClientCert).
+func ServerTLSConfig(clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+	pool, err := loadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// clientCertCN возвращает CN проверенного клиентского сертификата
+// или пустую строку, если клиент его не предъявил
+func clientCertCN(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// RequireClientCert пропускает запросы к /internal/* только с клиентским
+// сертификатом, подписанным CA агентов (401 в остальных случаях)
+func (h *Handlers) RequireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/internal/") && clientCertCN(r) == "" {
+			h.respondError(w, http.StatusUnauthorized, "agent client certificate required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// agentID — кто выполняет задачу: CN сертификата агента или имя его ключа
+// доступа. Заголовок X-Agent-ID учитывается только у анонимных запросов
+// (без API_KEYS_FILE): его выбирает сам клиент, и один ключ мог бы выдавать
+// себя за сколько угодно агентов и перевешивать честных при проверке результатов.
+func agentID(r *http.Request) string {
+	if cn := clientCertCN(r); cn != "" {
+		return cn
+	}
+	if c, ok := callerFrom(r); ok {
+		return c.name
+	}
+	return r.Header.Get("X-Agent-ID")
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m1tka051209/arithmetic-service/agent/worker"
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+)
+
+// testCA — удостоверяющий центр, создаваемый на время теста
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue выпускает сертификат сервера (для 127.0.0.1) или клиента с заданным CN
+// и записывает его и ключ в PEM-файлы
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func (ca *testCA) file(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, path, "CERTIFICATE", ca.cert.Raw)
+	return path
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, rogue := newTestCA(t), newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "orchestrator", x509.ExtKeyUsageServerAuth)
+	agentCert, agentKey := ca.issue(t, "agent-7", x509.ExtKeyUsageClientAuth)
+	rogueCert, rogueKey := rogue.issue(t, "agent-7", x509.ExtKeyUsageClientAuth)
+
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/expressions", h.ExpressionsHandler)
+	mux.HandleFunc("/internal/task", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.SubmitResultHandler(w, r)
+			return
+		}
+		h.GetTaskHandler(w, r)
+	})
+
+	srv := httptest.NewUnstartedServer(h.Authenticate(nil, AnonymousRole(nil, false), h.RequireClientCert(mux)))
+	tlsConfig, err := ServerTLSConfig(ca.file(t))
+	require.NoError(t, err)
+	pair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	require.NoError(t, err)
+	tlsConfig.Certificates = []tls.Certificate{pair}
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := ca.file(t)
+	agent, err := worker.NewClient(agentCert, agentKey, caFile)
+	require.NoError(t, err)
+	anonymous, err := worker.NewClient("", "", caFile)
+	require.NoError(t, err)
+	impostor, err := worker.NewClient(rogueCert, rogueKey, caFile)
+	require.NoError(t, err)
+
+	expr, err := tm.CreateExpression("2 + 3", task_manager.Options{NoOptimize: true})
+	require.NoError(t, err)
+
+	// Публичные маршруты доступны без сертификата
+	resp, err := anonymous.Get(srv.URL + "/api/v1/expressions")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Внутренние — только агенту с сертификатом нашего CA
+	resp, err = anonymous.Get(srv.URL + "/internal/task")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	_, err = impostor.Get(srv.URL + "/internal/task")
+	assert.Error(t, err, "a certificate from another CA must fail the handshake")
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/internal/task", nil)
+	req.Header.Set("X-Agent-ID", "spoofed")
+	resp, err = agent.Do(req)
+	require.NoError(t, err)
+	var got struct {
+		Task struct{ ID string } `json:"task"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// ID агента берётся из CN сертификата, а не из заголовка
+	task, _ := tm.GetTask(got.Task.ID)
+	assert.Equal(t, expr.ID, task.ExpressionID)
+	assert.Equal(t, "agent-7", task.AgentID)
+
+	resp, err = agent.Post(srv.URL+"/internal/task", "application/json",
+		strings.NewReader(`{"id": "`+got.Task.ID+`", "result": 5}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	expression, _ := tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expression.Status)
+}
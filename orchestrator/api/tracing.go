@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m1tka051209/arithmetic-service/logging"
+)
+
+// Trace открывает серверный спан запроса, продолжая трассу из заголовка
+// traceparent. Запросы агентов к /internal/* записываются, только если
+// продолжают трассу выражения (отправка результата задачи): иначе каждый
+// опрос очереди давал бы отдельную трассу. /metrics не записывается.
+func (h *Handlers) Trace(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		remote := trace.SpanContextFromContext(ctx).IsValid()
+		if r.URL.Path == "/metrics" || strings.HasPrefix(r.URL.Path, "/internal/") && !remote {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		name := route
+		if !strings.Contains(route, " ") {
+			// Шаблон маршрута без метода
+			name = r.Method + " " + route
+		}
+		ctx, span := tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", r.URL.Path),
+		))
+		defer span.End()
+		if id := logging.RequestID(ctx); id != "" {
+			span.SetAttributes(attribute.String("request.id", id))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/m1tka051209/arithmetic-service/orchestrator/api")
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+	"github.com/m1tka051209/arithmetic-service/tracing"
+)
+
+func TestTracePropagation(t *testing.T) {
+	exporter := tracing.InMemory()
+	tm := task_manager.NewTaskManager()
+	h := newTestHandlers(tm)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/calculate", h.CalculateHandler)
+	mux.HandleFunc("GET /metrics", h.MetricsHandler)
+	mux.HandleFunc("/internal/task", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.SubmitResultHandler(w, r)
+			return
+		}
+		h.GetTaskHandler(w, r)
+	})
+	server := h.Trace(mux, h.Authenticate(nil, AnonymousRole(nil, true), mux))
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do(httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2 + 3", "optimize": false}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	do(httptest.NewRequest("GET", "/metrics", nil))
+
+	// Опрос очереди не создаёт трасс, задача приходит с контекстом трассы выражения
+	rec = do(httptest.NewRequest("GET", "/internal/task", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var payload struct {
+		Task taskPayload `json:"task"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&payload))
+	require.Contains(t, payload.Task.TraceContext, "traceparent")
+
+	// Агент продолжает трассу и возвращает её с результатом
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(payload.Task.TraceContext))
+	ctx, execute := otel.Tracer("test").Start(ctx, "execute task")
+	req := httptest.NewRequest("POST", "/internal/task", strings.NewReader(`{"id": "`+payload.Task.ID+`", "result": 5}`))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	require.Equal(t, http.StatusOK, do(req).Code)
+	execute.End()
+
+	spans := exporter.GetSpans()
+	names := make([]string, 0, len(spans))
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range spans {
+		names = append(names, s.Name)
+		byName[s.Name] = s
+	}
+	assert.ElementsMatch(t, []string{
+		"POST /api/v1/calculate", "parse expression", "queue wait", "execute task",
+		"POST /internal/task", "task", "expression",
+	}, names)
+
+	request := byName["POST /api/v1/calculate"]
+	assert.Equal(t, trace.SpanKindServer, request.SpanKind)
+	for _, s := range spans {
+		assert.Equal(t, request.SpanContext.TraceID(), s.SpanContext.TraceID(), "%s is not in the expression trace", s.Name)
+	}
+	assert.Equal(t, request.SpanContext.SpanID(), byName["expression"].Parent.SpanID())
+	assert.Equal(t, byName["task"].SpanContext.SpanID(), byName["execute task"].Parent.SpanID())
+	assert.Equal(t, byName["execute task"].SpanContext.SpanID(), byName["POST /internal/task"].Parent.SpanID())
+}
@@ -2,21 +2,55 @@
 package main
 
 import (
-    "log"
+    "context"
+    "crypto/rand"
+    "errors"
+    "log/slog"
     "net/http"
+    "os"
+    "time"
 
+    "github.com/m1tka051209/arithmetic-service/config"
+    "github.com/m1tka051209/arithmetic-service/logging"
     "github.com/m1tka051209/arithmetic-service/orchestrator/api"
     "github.com/m1tka051209/arithmetic-service/orchestrator/task_manager"
+    "github.com/m1tka051209/arithmetic-service/tracing"
 )
 
 func main() {
+    logging.Setup()
+    if err := tracing.Setup(context.Background(), "orchestrator"); err != nil {
+        fatal("failed to configure tracing", err)
+    }
+    cfg := config.Load()
+    secret := []byte(cfg.JWTSecret)
+    if len(secret) == 0 {
+        secret = make([]byte, 32)
+        if _, err := rand.Read(secret); err != nil {
+            fatal("failed to generate JWT secret", err)
+        }
+        slog.Warn("JWT_SECRET is not set, user tokens are valid until restart")
+    }
+
     tm := task_manager.NewTaskManager()
-    handlers := api.NewHandlers(tm)
+    handlers := api.NewHandlers(tm, api.NewAccounts(secret, cfg.TokenTTL))
 
     // Регистрация маршрутов
+    http.HandleFunc("POST /api/v1/register", handlers.RegisterHandler)
+    http.HandleFunc("POST /api/v1/login", handlers.LoginHandler)
     http.HandleFunc("/api/v1/calculate", handlers.CalculateHandler)
+    http.HandleFunc("POST /api/v1/calculate/batch", handlers.BatchCalculateHandler)
     http.HandleFunc("/api/v1/expressions", handlers.ExpressionsHandler)
     http.HandleFunc("/api/v1/expressions/", handlers.GetExpressionHandler)
+    http.HandleFunc("GET /api/v1/expressions/{id}/graph", handlers.GraphHandler)
+    http.HandleFunc("GET /api/v1/expressions/{id}/tasks", handlers.ExpressionTasksHandler)
+    http.HandleFunc("GET /api/v1/tasks/{taskId}", handlers.TaskHandler)
+    http.HandleFunc("GET /admin/webhooks/dead-letters", handlers.DeadLettersHandler)
+    http.HandleFunc("GET /admin/agents", handlers.AgentsHandler)
+    http.HandleFunc("GET /admin/limits", handlers.LimitsHandler)
+    http.HandleFunc("GET /metrics", handlers.MetricsHandler)
+    http.HandleFunc("DELETE /admin/quarantine/{agent...}", handlers.ReleaseAgentHandler)
+    http.HandleFunc("POST /admin/webhooks/dead-letters/{id}/retry", handlers.RetryDeadLetterHandler)
     http.HandleFunc("/internal/task", func(w http.ResponseWriter, r *http.Request) {
         switch r.Method {
         case http.MethodGet:
@@ -28,6 +62,67 @@ func main() {
         }
     })
 
-    log.Println("🚀 Сервер запущен на :8080")
-    log.Fatal(http.ListenAndServe(":8080", nil))
+    var keys *api.KeyStore
+    if cfg.APIKeysFile != "" {
+        var err error
+        if keys, err = api.LoadKeys(cfg.APIKeysFile); err != nil {
+            fatal("failed to load API keys", err)
+        }
+    }
+    if cfg.AuthDisabled {
+        slog.Warn("AUTH_DISABLED is set, requests without a key or token may access every endpoint")
+    } else if keys == nil {
+        slog.Warn("API_KEYS_FILE is not set, only /api/* accepts requests without a key or token")
+    }
+    var handler http.Handler = handlers.Limit(api.Limits{
+        Rate:         cfg.RateLimit,
+        Burst:        cfg.RateBurst,
+        MaxBodyBytes: cfg.MaxBodyBytes,
+    }, http.DefaultServeMux)
+    if cfg.ClientCAFile != "" {
+        if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+            fatal("invalid TLS settings", errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE"))
+        }
+        handler = handlers.RequireClientCert(handler)
+    }
+    handler = handlers.Authenticate(keys, api.AnonymousRole(keys, cfg.AuthDisabled), handler)
+    handler = handlers.Instrument(http.DefaultServeMux, handler)
+    handler = handlers.Trace(http.DefaultServeMux, handler)
+    handler = handlers.RequestID(handler)
+
+    // Отдельный адрес для Prometheus: /metrics без ключа, но не на основном порту
+    if cfg.MetricsAddr != "" {
+        metricsMux := http.NewServeMux()
+        metricsMux.HandleFunc("GET /metrics", handlers.MetricsHandler)
+        go func() {
+            slog.Info("metrics server started", "addr", cfg.MetricsAddr)
+            fatal("metrics server stopped", http.ListenAndServe(cfg.MetricsAddr, metricsMux))
+        }()
+    }
+
+    server := &http.Server{
+        Addr:     ":8080",
+        Handler:  handler,
+        ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+    }
+    if cfg.TLSCertFile == "" {
+        slog.Info("server started", "addr", server.Addr)
+        fatal("server stopped", server.ListenAndServe())
+    }
+    tlsConfig, err := api.ServerTLSConfig(cfg.ClientCAFile)
+    if err != nil {
+        fatal("failed to configure TLS", err)
+    }
+    server.TLSConfig = tlsConfig
+    slog.Info("server started", "addr", server.Addr, "tls", true)
+    fatal("server stopped", server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile))
+}
+
+// fatal пишет ошибку в журнал, отправляет накопленные спаны и завершает процесс
+func fatal(msg string, err error) {
+    slog.Error(msg, "err", err)
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    tracing.Shutdown(ctx)
+    cancel()
+    os.Exit(1)
 }
\ No newline at end of file
@@ -1,7 +1,47 @@
 package models
 
+import (
+    "encoding/json"
+    "time"
+)
+
 type Expression struct {
-    ID     string  `json:"id"`
-    Status string  `json:"status"`
-    Result float64 `json:"result,omitempty"`
-}
\ No newline at end of file
+    ID           string              `json:"id"`
+    Status       string              `json:"status"`
+    Result       float64             `json:"result,omitempty"`
+    Exact        string              `json:"exact,omitempty"`        // точный результат "num/den" в режиме rational
+    Complex      string              `json:"complex,omitempty"`      // результат вида "1+2i" в режиме complex
+    Numeric      string              `json:"numeric,omitempty"`
+    Submitter    string              `json:"submitter,omitempty"`
+    Owner        string              `json:"owner,omitempty"`        // ID пользователя или "key:" и имя ключа доступа
+    CreatedAt    time.Time           `json:"created_at"`
+    CompletedAt  *time.Time          `json:"completed_at,omitempty"` // когда выражение получило результат или ошибку
+    Error        string              `json:"error,omitempty"`        // причина, если status == "error"
+    Boolean      *bool               `json:"-"`                      // логический результат; в JSON выводится в поле result
+    Bindings     map[string]Value    `json:"bindings,omitempty"`     // вычисленные значения let-переменных
+    BindingTasks map[string][]string `json:"-"`                      // ID задачи -> имена переменных, которым она присваивается
+    SavedTasks   int                 `json:"saved_tasks,omitempty"`  // задачи, сэкономленные устранением общих подвыражений
+    Elided       int                 `json:"elided_tasks,omitempty"` // операции, свёрнутые или упрощённые без отправки агентам
+    AST          []ASTNode           `json:"-"`                      // дерево разбора по инструкциям программы
+    CallbackURL  string              `json:"-"`                      // куда отправить выражение после завершения
+    RootTaskID   string              `json:"-"`
+}
+
+// MarshalJSON выводит логический результат как true/false, а ±Inf и NaN в Result —
+// строками, которые не поддерживает encoding/json
+func (e Expression) MarshalJSON() ([]byte, error) {
+    type plain Expression
+    if e.Boolean != nil {
+        return json.Marshal(struct {
+            plain
+            Result bool `json:"result"`
+        }{plain(e), *e.Boolean})
+    }
+    if e.Result == 0 {
+        return json.Marshal(plain(e))
+    }
+    return json.Marshal(struct {
+        plain
+        Result JSONFloat `json:"result"`
+    }{plain(e), JSONFloat(e.Result)})
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ASTNode — узел синтаксического дерева выражения
+type ASTNode struct {
+	Type  string    `json:"type"` // "number", "name", "operator", "call" или "let"
+	Value string    `json:"value"`
+	Args  []ASTNode `json:"args,omitempty"`
+}
+
+// Graph — разбор выражения: синтаксическое дерево каждой инструкции
+// и граф задач, в который оно превратилось
+type Graph struct {
+	ExpressionID string    `json:"expression_id"`
+	Root         string    `json:"root,omitempty"` // ID задачи, результат которой станет результатом выражения
+	AST          []ASTNode `json:"ast"`
+	Tasks        []Task    `json:"tasks"` // зависимости идут раньше задач, которые их используют
+}
+
+// DOT выводит граф в формате Graphviz: дерево разбора и граф задач
+// в отдельных кластерах. Рёбра графа задач идут от аргумента к потребителю.
+func (g Graph) DOT() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "digraph %s {\n", dotQuote("expression "+g.ExpressionID))
+	sb.WriteString("  node [shape=box, fontname=monospace];\n")
+
+	sb.WriteString("  subgraph cluster_ast {\n    label=\"AST\";\n")
+	next := 0
+	var writeNode func(node ASTNode) string
+	writeNode = func(node ASTNode) string {
+		id := fmt.Sprintf("ast%d", next)
+		next++
+		fmt.Fprintf(&sb, "    %s [label=%s];\n", id, dotQuote(node.Value))
+		for _, arg := range node.Args {
+			fmt.Fprintf(&sb, "    %s -> %s;\n", id, writeNode(arg))
+		}
+		return id
+	}
+	for _, node := range g.AST {
+		writeNode(node)
+	}
+	sb.WriteString("  }\n")
+
+	sb.WriteString("  subgraph cluster_tasks {\n    label=\"tasks\";\n")
+	for _, task := range g.Tasks {
+		label := fmt.Sprintf("%s\n%s\n%s", task.ID, taskLabel(task), task.Status)
+		switch {
+		case task.Status == "completed":
+			label += " = " + task.Result.String()
+		case task.Error != "":
+			label += ": " + task.Error
+		}
+		if task.AgentID != "" {
+			label += "\nagent " + task.AgentID
+		}
+		attrs := ""
+		if task.ID == g.Root {
+			attrs = ", peripheries=2"
+		}
+		fmt.Fprintf(&sb, "    %s [label=%s%s];\n", dotQuote(task.ID), dotQuote(label), attrs)
+		for i, ref := range []string{task.Arg1Ref, task.Arg2Ref, task.Arg3Ref} {
+			if ref != "" {
+				fmt.Fprintf(&sb, "    %s -> %s [label=\"arg%d\"];\n", dotQuote(ref), dotQuote(task.ID), i+1)
+			}
+		}
+	}
+	sb.WriteString("  }\n}\n")
+	return sb.String()
+}
+
+// taskLabel записывает операцию задачи с литеральными аргументами,
+// аргументы-ссылки обозначаются "#"
+func taskLabel(t Task) string {
+	arg := func(v Value, ref string) string {
+		if ref != "" {
+			return "#"
+		}
+		return v.String()
+	}
+	switch t.Operation {
+	case "if":
+		return fmt.Sprintf("if(%s, %s, %s)", arg(t.Arg1, t.Arg1Ref), arg(t.Arg2, t.Arg2Ref), arg(t.GetArg3(), t.Arg3Ref))
+	case "sqrt", "!":
+		return fmt.Sprintf("%s(%s)", t.Operation, arg(t.Arg1, t.Arg1Ref))
+	}
+	return fmt.Sprintf("%s %s %s", arg(t.Arg1, t.Arg1Ref), t.Operation, arg(t.Arg2, t.Arg2Ref))
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
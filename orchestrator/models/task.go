@@ -3,16 +3,37 @@ package models
 import "time"
 
 type Task struct {
-    ID            string        `json:"id"`
-    Arg1          float64       `json:"arg1"`
-    Arg2          float64       `json:"arg2"`
-    Operation     string        `json:"operation"`
-    OperationTime time.Duration `json:"-"`
-    Status        string        `json:"status"`
-    Result        float64       `json:"result,omitempty"`
-    ExpressionID  string        `json:"expression_id"`
+    ID              string        `json:"id"`
+    Arg1            Value         `json:"arg1"`
+    Arg2            Value         `json:"arg2"`
+    Arg1Ref         string        `json:"arg1_ref,omitempty"`     // ID задачи, результат которой станет Arg1
+    Arg2Ref         string        `json:"arg2_ref,omitempty"`     // ID задачи, результат которой станет Arg2
+    Arg3            *Value        `json:"arg3,omitempty"`         // ветка else у if, если она есть; выполняется оркестратором
+    Arg3Ref         string        `json:"arg3_ref,omitempty"`
+    Operation       string        `json:"operation"`
+    OperationTime   time.Duration `json:"-"`
+    Numeric         string        `json:"numeric,omitempty"`
+    NonFinite       string        `json:"non_finite,omitempty"`   // "error" или "ieee"
+    MaxRationalBits int           `json:"-"`                      // предел размера дроби, см. calc.Task
+    Status          string        `json:"status"`
+    Result          *Value        `json:"result,omitempty"`       // есть, если status == "completed"
+    Error           string        `json:"error,omitempty"`        // код ошибки, если status == "error"
+    ExpressionID    string        `json:"expression_id"`
+    AgentID         string        `json:"agent_id,omitempty"`     // агент, которому выдана задача
+    StartedAt       *time.Time    `json:"started_at,omitempty"`   // когда задача выдана агенту
+    CompletedAt     *time.Time    `json:"completed_at,omitempty"` // когда получен результат или ошибка
+    NoCache         bool          `json:"-"`                      // выражение отказалось от общего кэша результатов
+    VerifiedBy      []string      `json:"verified_by,omitempty"`  // агенты, чьи результаты совпали при проверке
 }
 
 func (t Task) GetOperationTimeMS() int {
     return int(t.OperationTime.Milliseconds())
-}
\ No newline at end of file
+}
+
+// GetArg3 возвращает ветку else у if; без неё — пустое значение
+func (t Task) GetArg3() Value {
+    if t.Arg3 == nil {
+        return Value{}
+    }
+    return *t.Arg3
+}
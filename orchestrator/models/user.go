@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// User — учётная запись пользователя. Пароль хранится только в виде bcrypt-хэша.
+type User struct {
+	ID           string    `json:"id"`
+	Login        string    `json:"login"`
+	PasswordHash []byte    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
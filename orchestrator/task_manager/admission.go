@@ -0,0 +1,100 @@
+package task_manager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrOverloaded = errors.New("orchestrator is overloaded")
+
+// admission — приём выражений с учётом очереди и числа агентов. Задачи
+// незавершённого выражения занимают место в очереди до его завершения;
+// выражение, которому места не хватает, отклоняется целиком.
+type admission struct {
+	maxTasks      int           // наибольшее число задач в очереди; 0 — без ограничения
+	tasksPerAgent int           // сколько задач в очереди приходится на одного активного агента; 0 — не учитывать агентов
+	activeWindow  time.Duration // агент активен, если запрашивал задачу не раньше этого срока
+	retryAfter    time.Duration // через сколько повторить отклонённый запрос
+
+	backlog int                  // задачи незавершённых выражений
+	agents  map[string]time.Time // ID агента -> когда он последний раз запрашивал задачу
+}
+
+// AdmissionStats — состояние очереди для мониторинга
+type AdmissionStats struct {
+	Backlog      int `json:"backlog"`
+	Capacity     int `json:"capacity"` // 0 — без ограничения
+	ActiveAgents int `json:"active_agents"`
+}
+
+// newAdmission читает MAX_PENDING_TASKS, TASKS_PER_AGENT, AGENT_ACTIVE_WINDOW
+// и OVERLOAD_RETRY_AFTER
+func newAdmission() *admission {
+	return &admission{
+		maxTasks:      max(getIntFromEnv("MAX_PENDING_TASKS", 100000), 0),
+		tasksPerAgent: max(getIntFromEnv("TASKS_PER_AGENT", 1000), 0),
+		activeWindow:  getIntervalFromEnv("AGENT_ACTIVE_WINDOW", 30*time.Second),
+		retryAfter:    getIntervalFromEnv("OVERLOAD_RETRY_AFTER", 5*time.Second),
+		agents:        make(map[string]time.Time),
+	}
+}
+
+// seen отмечает, что агент запросил задачу
+func (a *admission) seen(agentID string, now time.Time) {
+	a.agents[agentID] = now
+}
+
+// activeAgents считает агентов, запрашивавших задачи за activeWindow,
+// и забывает остальных
+func (a *admission) activeAgents(now time.Time) int {
+	for id, last := range a.agents {
+		if now.Sub(last) > a.activeWindow {
+			delete(a.agents, id)
+		}
+	}
+	return len(a.agents)
+}
+
+// capacity — сколько задач может быть в очереди. Пока ни один агент не
+// активен, место есть как у одного агента: иначе выражения, отправленные
+// до запуска агентов, отклонялись бы все.
+func (a *admission) capacity(now time.Time) int {
+	limit := a.maxTasks
+	if a.tasksPerAgent > 0 {
+		byAgents := a.tasksPerAgent * max(a.activeAgents(now), 1)
+		if limit == 0 || byAgents < limit {
+			limit = byAgents
+		}
+	}
+	return limit
+}
+
+// admit проверяет, поместятся ли в очередь tasks новых задач. Вызывается под tm.mu.
+func (tm *TaskManager) admit(tasks int) error {
+	a := tm.admission
+	if tasks == 0 {
+		return nil
+	}
+	if limit := a.capacity(time.Now()); limit > 0 && a.backlog+tasks > limit {
+		return fmt.Errorf("%w: %d tasks queued, capacity %d", ErrOverloaded, a.backlog, limit)
+	}
+	return nil
+}
+
+// Admission возвращает состояние очереди
+func (tm *TaskManager) Admission() AdmissionStats {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	now := time.Now()
+	return AdmissionStats{
+		Backlog:      tm.admission.backlog,
+		Capacity:     tm.admission.capacity(now),
+		ActiveAgents: tm.admission.activeAgents(now),
+	}
+}
+
+// RetryAfter — через сколько повторить запрос, отклонённый с ErrOverloaded
+func (tm *TaskManager) RetryAfter() time.Duration {
+	return tm.admission.retryAfter
+}
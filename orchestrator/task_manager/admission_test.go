@@ -0,0 +1,108 @@
+package task_manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmissionBacklog(t *testing.T) {
+	t.Setenv("MAX_PENDING_TASKS", "4")
+	t.Setenv("TASKS_PER_AGENT", "0")
+	tm := NewTaskManager()
+	opts := Options{NoOptimize: true, NoCache: true}
+
+	first, err := tm.CreateExpression("1 + 2 + 3", opts)
+	require.NoError(t, err)
+	_, err = tm.CreateExpression("4 * 5 * 6", opts)
+	require.NoError(t, err)
+	_, err = tm.CreateExpression("7 * 8", opts)
+	assert.ErrorIs(t, err, ErrOverloaded, "2 + 2 tasks fit, one more does not")
+	_, err = tm.CreateExpression("2 * 2", Options{})
+	assert.NoError(t, err, "a folded constant needs no tasks")
+	assert.Equal(t, AdmissionStats{Backlog: 4, Capacity: 4}, tm.Admission())
+
+	// Место освобождается, когда выражение завершается
+	runAgent(tm)
+	first, _ = tm.GetExpressionByID(first.ID)
+	require.Equal(t, "completed", first.Status)
+	assert.Equal(t, 0, tm.Admission().Backlog)
+	_, err = tm.CreateExpression("7 * 8", opts)
+	assert.NoError(t, err)
+}
+
+func TestAdmissionFailedExpression(t *testing.T) {
+	t.Setenv("MAX_PENDING_TASKS", "10")
+	tm := NewTaskManager()
+	_, err := tm.CreateExpression("1 / (2 - 2) + 3", Options{NoOptimize: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, tm.Admission().Backlog)
+	runAgent(tm)
+	assert.Equal(t, 0, tm.Admission().Backlog, "an expression that failed frees all of its tasks")
+}
+
+func TestAdmissionAgentCapacity(t *testing.T) {
+	t.Setenv("MAX_PENDING_TASKS", "5")
+	t.Setenv("TASKS_PER_AGENT", "2")
+	tm := NewTaskManager()
+	opts := Options{NoOptimize: true}
+
+	// Без агентов место есть как у одного
+	assert.Equal(t, AdmissionStats{Capacity: 2}, tm.Admission())
+	tm.GetNextTaskFor("a1")
+	tm.GetNextTaskFor("a2")
+	tm.GetNextTaskFor("a2")
+	assert.Equal(t, AdmissionStats{Capacity: 4, ActiveAgents: 2}, tm.Admission())
+	tm.GetNextTaskFor("a3")
+	assert.Equal(t, 5, tm.Admission().Capacity, "MAX_PENDING_TASKS caps agent capacity")
+
+	_, err := tm.CreateExpression("1 + 2 + 3 + 4 + 5", opts)
+	require.NoError(t, err)
+	_, err = tm.CreateExpression("1 + 2 + 3", opts)
+	assert.ErrorIs(t, err, ErrOverloaded)
+
+	// Агенты, давно не запрашивавшие задач, не учитываются
+	tm.admission.agents["a1"] = time.Now().Add(-time.Hour)
+	tm.admission.agents["a2"] = time.Now().Add(-time.Hour)
+	assert.Equal(t, AdmissionStats{Backlog: 4, Capacity: 2, ActiveAgents: 1}, tm.Admission())
+}
+
+func TestExpressionTaskLimit(t *testing.T) {
+	t.Setenv("MAX_EXPRESSION_TASKS", "2")
+	tm := NewTaskManager()
+	_, err := tm.CreateExpression("1 + 2 + 3 + 4", Options{NoOptimize: true})
+	assert.ErrorIs(t, err, ErrExpressionTooLarge)
+	_, err = tm.CreateExpression("1 + 2 + 3 + 4", Options{})
+	assert.NoError(t, err, "the limit applies after optimization")
+}
+
+func TestAdmissionBatch(t *testing.T) {
+	t.Setenv("MAX_PENDING_TASKS", "3")
+	t.Setenv("TASKS_PER_AGENT", "0")
+	tm := NewTaskManager()
+	opts := Options{NoOptimize: true, NoCache: true}
+
+	// Пакет принимается или отклоняется целиком, даже если первые элементы поместились бы
+	_, err := tm.CreateExpressions([]BatchItem{
+		{Expression: "1 + 2", Options: opts},
+		{Expression: "3 * 4", Options: opts},
+		{Expression: "5 - 6 - 7", Options: opts},
+	})
+	assert.ErrorIs(t, err, ErrOverloaded)
+	assert.Empty(t, tm.GetAllExpressions())
+	assert.Equal(t, 0, tm.Admission().Backlog)
+
+	// Некорректные элементы места не занимают
+	results, err := tm.CreateExpressions([]BatchItem{
+		{Expression: "1 + 2", Options: opts},
+		{Expression: "2 +", Options: opts},
+		{Expression: "5 - 6 - 7", Options: opts},
+	})
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, ErrInvalidExpression)
+	assert.NoError(t, results[2].Err)
+	assert.Equal(t, 3, tm.Admission().Backlog)
+}
@@ -0,0 +1,498 @@
+package task_manager
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+// operand — элемент стека при построении графа задач:
+// либо известное значение, либо ссылка на задачу, которая его вычислит
+type operand struct {
+	value   models.Value
+	ref     string
+	boolean bool // операнд логический: литерал true/false, сравнение, &&, || или !
+}
+
+// comparisons — операции сравнения; их выполняют агенты, результат логический
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// taskGraph — результат построения: задачи, корневой операнд
+// и значения let-переменных
+type taskGraph struct {
+	tasks    []models.Task
+	root     operand
+	bindings map[string]operand
+	saved    int // задачи, сэкономленные устранением общих подвыражений
+	elided   int // операции, свёрнутые или упрощённые без отправки агентам
+}
+
+// taskBuilder строит граф задач одного выражения
+type taskBuilder struct {
+	tm       *TaskManager
+	exprID   string
+	numeric  string
+	optimize bool // сворачивать константы и упрощать выражение, см. optimize.go
+	tasks    []models.Task
+	index    map[string]int     // ID задачи -> позиция в tasks
+	bindings map[string]operand // let-переменные, определённые к текущей инструкции
+	eager    map[string]bool    // задачи let-переменных и общие подвыражения: вычисляются всегда, даже внутри невыбранной ветви
+	nodes    map[string]string  // ключ подвыражения -> ID задачи, которая его вычисляет
+	saved    int                // сколько задач не создано благодаря повторному использованию
+	elided   int                // сколько операций свёрнуто или упрощено
+}
+
+// buildTasks превращает программу в граф задач. Задача, аргумент которой зависит
+// от другой задачи, получает ссылку Arg1Ref/Arg2Ref и ждёт её результата.
+// Ветви if, && и || создаются отложенными и запускаются, только когда
+// оркестратор узнает значение условия. Каждая let-переменная и каждое
+// повторяющееся подвыражение — один узел графа, от которого зависят все
+// задачи, где они используются.
+func (tm *TaskManager) buildTasks(exprID string, program []statement, numeric string, optimize bool) (taskGraph, error) {
+	b := &taskBuilder{
+		tm:       tm,
+		exprID:   exprID,
+		numeric:  numeric,
+		optimize: optimize,
+		index:    make(map[string]int),
+		bindings: make(map[string]operand),
+		eager:    make(map[string]bool),
+		nodes:    make(map[string]string),
+	}
+
+	var root operand
+	for _, stmt := range program {
+		value, err := b.build(stmt.rpn)
+		if err != nil {
+			return taskGraph{}, err
+		}
+		if stmt.name == "" {
+			root = value
+			continue
+		}
+		b.bindings[stmt.name] = value
+		if value.ref != "" {
+			b.eager[value.ref] = true
+		}
+	}
+	if optimize {
+		roots := []operand{root}
+		for _, value := range b.bindings {
+			roots = append(roots, value)
+		}
+		b.prune(roots)
+	}
+	// Деление на литеральный ноль, которое выполнится при любом условии, видно
+	// сразу — не отправляем его агентам. В отложенной ветви оно может не понадобиться.
+	for _, task := range b.tasks {
+		if (task.Status == "pending" || task.Status == "waiting") && dividesByZero(task) {
+			return taskGraph{}, ErrDivisionByZero
+		}
+	}
+	return taskGraph{tasks: b.tasks, root: root, bindings: b.bindings, saved: b.saved, elided: b.elided}, nil
+}
+
+// build строит задачи одного выражения в ОПН и возвращает его операнд
+func (b *taskBuilder) build(rpn []string) (operand, error) {
+	numeric := b.numeric
+	var stack []operand
+
+	pop := func(n int) ([]operand, error) {
+		if len(stack) < n {
+			return nil, ErrInvalidExpression
+		}
+		args := append([]operand(nil), stack[len(stack)-n:]...)
+		stack = stack[:len(stack)-n]
+		return args, nil
+	}
+
+	for _, token := range rpn {
+		var result operand
+		switch {
+		case isIdent(token) && b.bindings[token] != (operand{}):
+			result = b.bindings[token]
+		case isNumber(token) || isImaginary(token) || isIdent(token) && functions[token] == 0:
+			value, err := parseLiteral(token, numeric)
+			if err != nil {
+				return operand{}, err
+			}
+			result = operand{value: value, boolean: value.IsBool()}
+		case token == unaryMinus || token == "!":
+			args, err := pop(1)
+			if err != nil {
+				return operand{}, err
+			}
+			if result, err = b.unary(token, args[0]); err != nil {
+				return operand{}, err
+			}
+		case token == "if":
+			args, err := pop(3)
+			if err != nil {
+				return operand{}, err
+			}
+			if result, err = b.control(token, args[0], args[1:]...); err != nil {
+				return operand{}, err
+			}
+		case token == "&&" || token == "||":
+			args, err := pop(2)
+			if err != nil {
+				return operand{}, err
+			}
+			if result, err = b.control(token, args[0], args[1]); err != nil {
+				return operand{}, err
+			}
+		case token == "sqrt":
+			args, err := pop(1)
+			if err != nil {
+				return operand{}, err
+			}
+			if numeric == models.NumericRational {
+				return operand{}, fmt.Errorf("%w: sqrt is not supported in rational mode", ErrInvalidExpression)
+			}
+			if err := expectNumbers(token, args[0]); err != nil {
+				return operand{}, err
+			}
+			var folded bool
+			if result, folded = b.fold(token, args[0], operand{value: zero(numeric)}); !folded {
+				result = b.task(token, args[0], operand{value: zero(numeric)})
+			}
+		default:
+			args, err := pop(2)
+			if err != nil {
+				return operand{}, err
+			}
+			if result, err = b.binary(token, args[0], args[1]); err != nil {
+				return operand{}, err
+			}
+		}
+		stack = append(stack, result)
+	}
+
+	if len(stack) != 1 {
+		return operand{}, ErrInvalidExpression
+	}
+	return stack[0], nil
+}
+
+func (b *taskBuilder) unary(op string, arg operand) (operand, error) {
+	if op == "!" {
+		if err := expectBools(op, arg); err != nil {
+			return operand{}, err
+		}
+		if arg.ref == "" {
+			return operand{value: models.Bool(!arg.value.Bool), boolean: true}, nil
+		}
+		result := b.task(op, arg, operand{value: models.Bool(false)})
+		result.boolean = true
+		return result, nil
+	}
+
+	if err := expectNumbers("-", arg); err != nil {
+		return operand{}, err
+	}
+	if arg.ref == "" {
+		return operand{value: negate(arg.value)}, nil
+	}
+	return b.task("-", operand{value: zero(b.numeric)}, arg), nil
+}
+
+func (b *taskBuilder) binary(op string, arg1, arg2 operand) (operand, error) {
+	switch {
+	case op == "==" || op == "!=":
+		if arg1.boolean != arg2.boolean {
+			return operand{}, fmt.Errorf("%w: %s compares a boolean with a number", ErrInvalidExpression, op)
+		}
+	case comparisons[op]:
+		if err := expectNumbers(op, arg1, arg2); err != nil {
+			return operand{}, err
+		}
+		if b.numeric == models.NumericComplex {
+			return operand{}, fmt.Errorf("%w: %s is not defined for complex numbers", ErrInvalidExpression, op)
+		}
+	default:
+		if err := expectNumbers(op, arg1, arg2); err != nil {
+			return operand{}, err
+		}
+	}
+
+	if result, ok := b.fold(op, arg1, arg2); ok {
+		return result, nil
+	}
+	if result, ok := b.simplify(op, arg1, arg2); ok {
+		return result, nil
+	}
+	result := b.task(op, arg1, arg2)
+	result.boolean = comparisons[op]
+	return result, nil
+}
+
+// control строит if, && или ||. Их выполняет сам оркестратор: сначала
+// вычисляется условие, затем запускается только выбранная ветвь.
+// Если условие известно заранее, ветвь выбирается сразу.
+func (b *taskBuilder) control(op string, cond operand, branches ...operand) (operand, error) {
+	if err := expectBools(op, cond); err != nil {
+		return operand{}, err
+	}
+	if op == "if" {
+		if branches[0].boolean != branches[1].boolean {
+			return operand{}, fmt.Errorf("%w: if branches have different types", ErrInvalidExpression)
+		}
+	} else if err := expectBools(op, branches[0]); err != nil {
+		return operand{}, err
+	}
+
+	if cond.ref == "" {
+		chosen, skipped := chooseBranch(op, cond.value.Bool, cond, branches)
+		for _, branch := range skipped {
+			b.mark(branch.ref, "skipped")
+		}
+		return chosen, nil
+	}
+	if op != "if" {
+		if result, ok := b.simplifyLogical(op, cond, branches[0]); ok {
+			return result, nil
+		}
+	}
+
+	key := nodeKey(op, append([]operand{cond}, branches...)...)
+	if id, ok := b.reuse(key); ok {
+		return operand{ref: id, boolean: branches[0].boolean}, nil
+	}
+
+	task := models.Task{
+		ID:           b.tm.GenerateID(),
+		Arg1Ref:      cond.ref,
+		Arg2:         branches[0].value,
+		Arg2Ref:      branches[0].ref,
+		Operation:    op,
+		Numeric:      b.numeric,
+		NonFinite:    b.tm.nonFinite,
+		Status:       "waiting",
+		ExpressionID: b.exprID,
+	}
+	if len(branches) > 1 {
+		task.Arg3, task.Arg3Ref = &branches[1].value, branches[1].ref
+	}
+	for _, branch := range branches {
+		b.mark(branch.ref, "deferred")
+	}
+	b.add(key, task)
+	return operand{ref: task.ID, boolean: branches[0].boolean}, nil
+}
+
+// dividesByZero сообщает, что задача делит на литеральный ноль в режиме,
+// где такое деление — ошибка
+func dividesByZero(task models.Task) bool {
+	return task.Operation == "/" && task.Arg2Ref == "" && isZero(task.Arg2) &&
+		(task.Numeric == models.NumericRational || task.NonFinite == models.NonFiniteError)
+}
+
+// chooseBranch возвращает результат управляющей операции по известному условию
+// и ветви, которые вычислять не нужно
+func chooseBranch(op string, cond bool, condOperand operand, branches []operand) (operand, []operand) {
+	switch {
+	case op == "if" && cond:
+		return branches[0], branches[1:]
+	case op == "if":
+		return branches[1], branches[:1]
+	case op == "&&" && !cond, op == "||" && cond:
+		return condOperand, branches
+	default:
+		return branches[0], nil
+	}
+}
+
+// task добавляет задачу для агента. Если такая же операция над теми же
+// аргументами уже есть в графе, возвращается ссылка на неё.
+func (b *taskBuilder) task(op string, arg1, arg2 operand) operand {
+	key := nodeKey(op, arg1, arg2)
+	if id, ok := b.reuse(key); ok {
+		return operand{ref: id}
+	}
+
+	task := models.Task{
+		ID:              b.tm.GenerateID(),
+		Arg1:            arg1.value,
+		Arg2:            arg2.value,
+		Arg1Ref:         arg1.ref,
+		Arg2Ref:         arg2.ref,
+		Operation:       op,
+		OperationTime:   b.tm.operationTime[op],
+		Numeric:         b.numeric,
+		NonFinite:       b.tm.nonFinite,
+		MaxRationalBits: b.tm.maxRationalBits,
+		Status:          "pending",
+		ExpressionID:    b.exprID,
+	}
+	if task.Arg1Ref != "" || task.Arg2Ref != "" {
+		task.Status = "waiting"
+	}
+	b.add(key, task)
+	return operand{ref: task.ID}
+}
+
+func (b *taskBuilder) add(key string, task models.Task) {
+	b.index[task.ID] = len(b.tasks)
+	b.tasks = append(b.tasks, task)
+	b.nodes[key] = task.ID
+}
+
+// reuse ищет задачу с тем же ключом. Найденная задача становится общей:
+// у неё несколько потребителей, и не все они обязаны лежать в одной ветви,
+// поэтому она вычисляется всегда, а если уже была отложена — возвращается в работу.
+func (b *taskBuilder) reuse(key string) (string, bool) {
+	id, ok := b.nodes[key]
+	if !ok {
+		return "", false
+	}
+	b.saved++
+	b.eager[id] = true
+	b.restore(id)
+	return id, true
+}
+
+// restore снимает с задачи и её зависимостей пометку deferred или skipped.
+// У управляющей задачи, как и при activate, возвращается в работу только условие.
+func (b *taskBuilder) restore(ref string) {
+	i, ok := b.index[ref]
+	if !ok || b.tasks[i].Status != "deferred" && b.tasks[i].Status != "skipped" {
+		return
+	}
+	task := &b.tasks[i]
+	if isControl(task.Operation) {
+		task.Status = "waiting"
+		b.restore(task.Arg1Ref)
+		return
+	}
+	task.Status = "pending"
+	if task.Arg1Ref != "" || task.Arg2Ref != "" {
+		task.Status = "waiting"
+	}
+	b.restore(task.Arg1Ref)
+	b.restore(task.Arg2Ref)
+}
+
+// commutative — операции, для которых порядок аргументов не важен:
+// a+b и b+a считаются одним подвыражением
+var commutative = map[string]bool{"+": true, "*": true, "==": true, "!=": true}
+
+// nodeKey строит ключ подвыражения из операции и аргументов. Аргумент-задача
+// записывается своим ID, литерал — видом и значением.
+func nodeKey(op string, args ...operand) string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		if arg.ref != "" {
+			keys[i] = "#" + arg.ref
+		} else {
+			keys[i] = arg.value.Numeric() + ":" + arg.value.String()
+		}
+	}
+	if commutative[op] && keys[1] < keys[0] {
+		keys[0], keys[1] = keys[1], keys[0]
+	}
+	return op + "(" + strings.Join(keys, ",") + ")"
+}
+
+// mark присваивает статус задаче и всем задачам, от которых она зависит.
+// Задачи let-переменных не трогаются: их значения нужны независимо от ветви.
+func (b *taskBuilder) mark(ref, status string) {
+	i, ok := b.index[ref]
+	if !ok || b.eager[ref] {
+		return
+	}
+	b.tasks[i].Status = status
+	for _, dep := range []string{b.tasks[i].Arg1Ref, b.tasks[i].Arg2Ref, b.tasks[i].Arg3Ref} {
+		b.mark(dep, status)
+	}
+}
+
+func expectNumbers(op string, args ...operand) error {
+	for _, arg := range args {
+		if arg.boolean {
+			return fmt.Errorf("%w: %s expects numbers", ErrInvalidExpression, op)
+		}
+	}
+	return nil
+}
+
+func expectBools(op string, args ...operand) error {
+	for _, arg := range args {
+		if !arg.boolean {
+			return fmt.Errorf("%w: %s expects booleans", ErrInvalidExpression, op)
+		}
+	}
+	return nil
+}
+
+func parseLiteral(token, numeric string) (models.Value, error) {
+	if isIdent(token) {
+		switch {
+		case token == "true" || token == "false":
+			return models.Bool(token == "true"), nil
+		case token == "i" && numeric == models.NumericComplex:
+			return models.Complex(1i), nil
+		}
+		return models.Value{}, fmt.Errorf("%w: unknown identifier %q", ErrInvalidExpression, token)
+	}
+
+	if isImaginary(token) {
+		if numeric != models.NumericComplex {
+			return models.Value{}, fmt.Errorf("%w: imaginary literal %q requires numeric mode \"complex\"", ErrInvalidExpression, token)
+		}
+		_, f, err := parseNumber(strings.TrimSuffix(token, "i"))
+		if err != nil {
+			return models.Value{}, fmt.Errorf("%w: %w", ErrInvalidExpression, err)
+		}
+		return models.Complex(complex(0, f)), nil
+	}
+
+	r, f, err := parseNumber(token)
+	if err != nil {
+		return models.Value{}, fmt.Errorf("%w: %w", ErrInvalidExpression, err)
+	}
+	switch numeric {
+	case models.NumericRational:
+		return models.Rational(r), nil
+	case models.NumericComplex:
+		return models.Complex(complex(f, 0)), nil
+	default:
+		return models.Float(f), nil
+	}
+}
+
+// zero возвращает ноль в заданном числовом режиме
+func zero(numeric string) models.Value {
+	switch numeric {
+	case models.NumericRational:
+		return models.Rational(new(big.Rat))
+	case models.NumericComplex:
+		return models.Complex(0)
+	default:
+		return models.Float(0)
+	}
+}
+
+func isZero(v models.Value) bool {
+	switch v.Numeric() {
+	case models.NumericRational:
+		return v.Rat.Sign() == 0
+	case models.NumericComplex:
+		return v.Complex == 0
+	default:
+		return v.Float == 0
+	}
+}
+
+func negate(v models.Value) models.Value {
+	switch v.Numeric() {
+	case models.NumericRational:
+		return models.Rational(new(big.Rat).Neg(v.Rat))
+	case models.NumericComplex:
+		// 0 - v, а не -v: иначе мнимая часть станет -0 и sqrt уйдёт на другой берег разреза
+		return models.Complex(0 - v.Complex)
+	default:
+		return models.Float(-v.Float)
+	}
+}
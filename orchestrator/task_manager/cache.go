@@ -0,0 +1,182 @@
+package task_manager
+
+import (
+	"container/list"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+// resultCache — общий для всех выражений кэш результатов задач. Ключ — операция,
+// режим вычисления и значения аргументов, поэтому одинаковые вычисления из разных
+// выражений выполняются агентами один раз. Размер ограничен, при переполнении
+// вытесняется давно не использованный результат.
+type resultCache struct {
+	capacity  int
+	entries   map[string]*list.Element // ключ -> элемент order
+	order     *list.List               // от недавно использованных к давно не использованным
+	inflight  map[string]string        // ключ -> ID задачи, которая сейчас вычисляется
+	followers map[string][]string      // ID такой задачи -> задачи, ждущие её результат
+	hits      int
+	misses    int
+}
+
+type cacheEntry struct {
+	key   string
+	value models.Value
+}
+
+// CacheStats — счётчики кэша результатов
+type CacheStats struct {
+	Hits     int `json:"hits"`   // задачи, получившие готовый результат или присоединённые к выполняемой
+	Misses   int `json:"misses"` // задачи, отправленные агентам
+	Size     int `json:"size"`
+	Capacity int `json:"capacity"`
+}
+
+func newResultCache(capacity int) *resultCache {
+	return &resultCache{
+		capacity:  capacity,
+		entries:   make(map[string]*list.Element),
+		order:     list.New(),
+		inflight:  make(map[string]string),
+		followers: make(map[string][]string),
+	}
+}
+
+func (c *resultCache) get(key string) (models.Value, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return models.Value{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(cacheEntry).value, true
+}
+
+func (c *resultCache) put(key string, value models.Value) {
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = cacheEntry{key, value}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(cacheEntry{key, value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cacheEntry).key)
+	}
+}
+
+// resultKey — ключ вычисления: операция, режимы и значения аргументов
+func resultKey(task models.Task) string {
+	return task.Numeric + "|" + task.NonFinite + "|" +
+		nodeKey(task.Operation, operand{value: task.Arg1}, operand{value: task.Arg2})
+}
+
+// cacheable сообщает, может ли задача брать результат из кэша и пополнять его
+func (tm *TaskManager) cacheable(task models.Task) bool {
+	return tm.cache != nil && !task.NoCache && !isControl(task.Operation)
+}
+
+// enqueue ставит готовую задачу в очередь агентов. Если такое же вычисление уже
+// выполнено, задача сразу завершается его результатом, а если оно выполняется
+// прямо сейчас — задача ждёт его результата, не занимая агента.
+// Вызывается под tm.mu после сохранения задачи в tm.tasks.
+func (tm *TaskManager) enqueue(task models.Task) {
+	if !tm.cacheable(task) {
+		tm.pushQueue(task.ID)
+		return
+	}
+
+	key := resultKey(task)
+	if result, ok := tm.cache.get(key); ok {
+		tm.cache.hits++
+		tm.traces.task(task.ID).SetAttributes(attribute.Bool("task.cached", true))
+		tm.completeTask(task, result)
+		return
+	}
+	if leader, ok := tm.cache.inflight[key]; ok {
+		tm.cache.hits++
+		task.Status = "waiting"
+		tm.tasks[task.ID] = task
+		tm.cache.followers[leader] = append(tm.cache.followers[leader], task.ID)
+		return
+	}
+	tm.cache.misses++
+	tm.cache.inflight[key] = task.ID
+	tm.pushQueue(task.ID)
+}
+
+// settleFollowers сохраняет результат выполненной задачи в кэш и передаёт его
+// (или код ошибки) задачам, присоединённым к ней. Вызывается под tm.mu.
+func (tm *TaskManager) settleFollowers(task models.Task, result models.Value, code string) {
+	if !tm.cacheable(task) {
+		return
+	}
+	key := resultKey(task)
+	if tm.cache.inflight[key] != task.ID {
+		return
+	}
+	delete(tm.cache.inflight, key)
+	if code == "" {
+		tm.cache.put(key, result)
+	}
+
+	followers := tm.cache.followers[task.ID]
+	delete(tm.cache.followers, task.ID)
+	for _, id := range followers {
+		follower := tm.tasks[id]
+		if follower.Status != "waiting" {
+			continue
+		}
+		if code != "" {
+			tm.failTask(follower, code)
+		} else {
+			tm.completeTask(follower, result)
+		}
+	}
+}
+
+// handOff передаёт вычисление следующей присоединённой задаче, когда выполняемая
+// задача отменена вместе со своим выражением. Вызывается под tm.mu.
+func (tm *TaskManager) handOff(task models.Task) {
+	if !tm.cacheable(task) {
+		return
+	}
+	key := resultKey(task)
+	if tm.cache.inflight[key] != task.ID {
+		return
+	}
+	delete(tm.cache.inflight, key)
+
+	followers := tm.cache.followers[task.ID]
+	delete(tm.cache.followers, task.ID)
+	for i, id := range followers {
+		follower := tm.tasks[id]
+		if follower.Status != "waiting" {
+			continue
+		}
+		follower.Status = "pending"
+		tm.tasks[id] = follower
+		tm.cache.inflight[key] = id
+		tm.pushQueue(id)
+		tm.cache.followers[id] = append(tm.cache.followers[id], followers[i+1:]...)
+		return
+	}
+}
+
+// CacheStats возвращает счётчики кэша результатов
+func (tm *TaskManager) CacheStats() CacheStats {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.cache == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:     tm.cache.hits,
+		Misses:   tm.cache.misses,
+		Size:     tm.cache.order.Len(),
+		Capacity: tm.cache.capacity,
+	}
+}
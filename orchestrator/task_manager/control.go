@@ -0,0 +1,75 @@
+package task_manager
+
+import "github.com/m1tka051209/arithmetic-service/orchestrator/models"
+
+// isControl сообщает, что операцию выполняет сам оркестратор, а не агент:
+// у if, && и || вычисляется только нужная ветвь
+func isControl(op string) bool {
+	return op == "if" || op == "&&" || op == "||"
+}
+
+// advanceControl продвигает управляющую задачу: как только известно условие,
+// запускает выбранную ветвь и пропускает остальные, а когда ветвь вычислена —
+// завершает задачу её результатом. Вызывается под tm.mu.
+func (tm *TaskManager) advanceControl(task models.Task) {
+	if !tm.isResolved(task.Arg1Ref) {
+		return
+	}
+
+	branches := []operand{{value: task.Arg2, ref: task.Arg2Ref}}
+	if task.Operation == "if" {
+		branches = append(branches, operand{value: task.GetArg3(), ref: task.Arg3Ref})
+	}
+	chosen, skipped := chooseBranch(task.Operation, task.Arg1.Bool, operand{value: task.Arg1}, branches)
+	for _, branch := range skipped {
+		tm.skip(branch.ref)
+	}
+
+	if chosen.ref == "" {
+		tm.completeTask(task, chosen.value)
+		return
+	}
+	if dep := tm.tasks[chosen.ref]; dep.Status == "completed" {
+		tm.completeTask(task, *dep.Result)
+		return
+	}
+	tm.activate(chosen.ref)
+}
+
+// activate запускает отложенную ветвь: задачи с известными аргументами
+// попадают в очередь, у вложенных if, && и || запускается только условие
+func (tm *TaskManager) activate(id string) {
+	task, ok := tm.tasks[id]
+	if !ok || task.Status != "deferred" && task.Status != "skipped" {
+		return
+	}
+	task.Status = "waiting"
+	tm.tasks[id] = task
+
+	if isControl(task.Operation) {
+		tm.activate(task.Arg1Ref)
+		tm.advanceControl(task)
+		return
+	}
+
+	tm.activate(task.Arg1Ref)
+	tm.activate(task.Arg2Ref)
+	if tm.isResolved(task.Arg1Ref) && tm.isResolved(task.Arg2Ref) {
+		task.Status = "pending"
+		tm.tasks[id] = task
+		tm.enqueue(task)
+	}
+}
+
+// skip помечает невыбранную ветвь: её задачи никогда не попадут к агентам
+func (tm *TaskManager) skip(id string) {
+	task, ok := tm.tasks[id]
+	if !ok || task.Status != "deferred" {
+		return
+	}
+	task.Status = "skipped"
+	tm.tasks[id] = task
+	for _, ref := range []string{task.Arg1Ref, task.Arg2Ref, task.Arg3Ref} {
+		tm.skip(ref)
+	}
+}
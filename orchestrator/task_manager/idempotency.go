@@ -0,0 +1,78 @@
+package task_manager
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrIdempotencyConflict = errors.New("idempotency key was already used with a different request")
+
+// idempotencyRecord — выражение, созданное запросом с ключом идемпотентности
+type idempotencyRecord struct {
+	requestHash  string
+	expressionID string
+	expires      time.Time
+}
+
+// idempotencyStore хранит ключи идемпотентности в течение ttl. Все ключи
+// живут одинаково долго, поэтому порядок добавления совпадает с порядком
+// истечения, и устаревшие ключи снимаются с начала очереди.
+type idempotencyStore struct {
+	ttl     time.Duration
+	records map[string]idempotencyRecord
+	order   []idempotencyEntry // ключи в порядке добавления
+}
+
+// idempotencyEntry — место ключа в очереди. Ключ, использованный снова после
+// истечения, попадает в очередь ещё раз; прежняя запись узнаётся по сроку,
+// который не совпадает со сроком текущей записи.
+type idempotencyEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{ttl: ttl, records: make(map[string]idempotencyRecord)}
+}
+
+// lookup возвращает ID выражения, созданного с этим ключом. Ключ, использованный
+// с другим телом запроса, — ErrIdempotencyConflict.
+func (s *idempotencyStore) lookup(key, requestHash string, now time.Time) (string, bool, error) {
+	record, ok := s.records[key]
+	if !ok || now.After(record.expires) {
+		return "", false, nil
+	}
+	if record.requestHash != requestHash {
+		return "", false, ErrIdempotencyConflict
+	}
+	return record.expressionID, true, nil
+}
+
+func (s *idempotencyStore) remember(key, requestHash, expressionID string, now time.Time) {
+	for len(s.order) > 0 {
+		oldest := s.order[0]
+		if record, ok := s.records[oldest.key]; ok && record.expires.Equal(oldest.expires) {
+			if !now.After(record.expires) {
+				break
+			}
+			delete(s.records, oldest.key)
+		}
+		s.order = s.order[1:]
+	}
+	expires := now.Add(s.ttl)
+	s.records[key] = idempotencyRecord{requestHash: requestHash, expressionID: expressionID, expires: expires}
+	s.order = append(s.order, idempotencyEntry{key: key, expires: expires})
+}
+
+// replay ищет выражение, уже созданное с ключом из opts. Вызывается под tm.mu.
+func (tm *TaskManager) replay(opts Options) (string, bool, error) {
+	if opts.IdempotencyKey == "" {
+		return "", false, nil
+	}
+	return tm.idempotency.lookup(idempotencyKey(opts), opts.RequestHash, time.Now())
+}
+
+// idempotencyKey — ключ хранилища: ключи разных владельцев и отправителей не пересекаются
+func idempotencyKey(opts Options) string {
+	return opts.Owner + "\x00" + opts.Submitter + "\x00" + opts.IdempotencyKey
+}
@@ -0,0 +1,56 @@
+package task_manager
+
+import (
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+// Аренда задачи: агент, взявший задачу, должен вернуть ответ до срока —
+// TASK_LEASE_TIMEOUT сверх времени операции. Иначе (например, агент упал)
+// задача возвращается в очередь и достаётся другому агенту; без этого она
+// осталась бы in_progress навсегда, а её выражение не завершилось бы.
+// Проверяемые задачи не арендуются: их ограничивает VERIFY_ROUND_TIMEOUT.
+
+// lease назначает срок аренды только что выданной задаче. Вызывается под tm.mu.
+func (tm *TaskManager) lease(task models.Task, now time.Time) {
+	if tm.verify != nil {
+		if _, verified := tm.verify.rounds[task.ID]; verified {
+			return
+		}
+	}
+	tm.leases[task.ID] = now.Add(task.OperationTime + tm.leaseTimeout)
+}
+
+// expireLeases возвращает в очередь задачи, аренда которых истекла.
+// Записи о задачах, уже получивших ответ, удаляются. Вызывается под tm.mu.
+func (tm *TaskManager) expireLeases(now time.Time) {
+	for id, deadline := range tm.leases {
+		task := tm.tasks[id]
+		if task.Status != "in_progress" {
+			delete(tm.leases, id)
+			continue
+		}
+		if now.Before(deadline) {
+			continue
+		}
+		delete(tm.leases, id)
+		slog.Warn("task lease expired", "task_id", id, "expression_id", task.ExpressionID, "agent_id", task.AgentID)
+		tm.traces.task(id).AddEvent("lease expired", trace.WithAttributes(attribute.String("agent.id", task.AgentID)))
+		tm.metrics.leaseExpirations.Inc(task.Operation)
+		tm.requeue(task)
+	}
+}
+
+// requeue снимает задачу с агента и возвращает её в очередь. Вызывается под tm.mu.
+func (tm *TaskManager) requeue(task models.Task) {
+	task.Status = "pending"
+	task.AgentID = ""
+	task.StartedAt = nil
+	tm.tasks[task.ID] = task
+	tm.pushQueue(task.ID)
+}
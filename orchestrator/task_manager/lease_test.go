@@ -0,0 +1,67 @@
+package task_manager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+func TestTaskLeaseExpiry(t *testing.T) {
+	t.Setenv("TASK_LEASE_TIMEOUT", "20ms")
+	t.Setenv("TIME_ADDITION_MS", "0")
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("1 + 2", Options{NoOptimize: true})
+	require.NoError(t, err)
+
+	task, ok := tm.GetNextTaskFor("crashed")
+	require.True(t, ok)
+	_, ok = tm.GetNextTaskFor("a2")
+	assert.False(t, ok, "a leased task is not handed out twice")
+
+	// Агент упал и не ответил: по истечении аренды задачу берёт другой агент
+	time.Sleep(30 * time.Millisecond)
+	again, ok := tm.GetNextTaskFor("a2")
+	require.True(t, ok)
+	assert.Equal(t, task.ID, again.ID)
+	assert.Equal(t, "a2", again.AgentID)
+
+	_, err = tm.SaveTaskResultFrom(again.ID, "a2", models.Float(3))
+	require.NoError(t, err)
+	_, err = tm.SaveTaskResultFrom(task.ID, "crashed", models.Float(3))
+	assert.ErrorIs(t, err, ErrTaskCompleted)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, 3.0, expr.Result)
+
+	// Ответ, пришедший вовремя, аренду снимает
+	time.Sleep(30 * time.Millisecond)
+	tm.GetNextTaskFor("a2")
+	assert.Empty(t, tm.leases)
+
+	var b strings.Builder
+	_, err = tm.Metrics().WriteTo(&b)
+	require.NoError(t, err)
+	assert.Contains(t, b.String(), `arithmetic_task_lease_expirations_total{operation="+"} 1`+"\n")
+}
+
+func TestVerifiedTasksAreNotLeased(t *testing.T) {
+	t.Setenv("TASK_LEASE_TIMEOUT", "1ms")
+	t.Setenv("VERIFY_SAMPLE_RATE", "1")
+	tm := NewTaskManager()
+	_, err := tm.CreateExpression("1 + 2", Options{NoOptimize: true})
+	require.NoError(t, err)
+
+	task, ok := tm.GetNextTaskFor("a1")
+	require.True(t, ok)
+	time.Sleep(5 * time.Millisecond)
+	replica, ok := tm.GetNextTaskFor("a2")
+	require.True(t, ok, "the second replica goes to another agent")
+	assert.Equal(t, task.ID, replica.ID)
+	_, ok = tm.GetNextTaskFor("a3")
+	assert.False(t, ok, "the round timeout, not the lease, bounds a verified task")
+}
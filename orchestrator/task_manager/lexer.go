@@ -0,0 +1,228 @@
+package task_manager
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// tokenize разбивает выражение на токены: числа, мнимые литералы ("2i"),
+// идентификаторы, операторы (в том числе сравнения и логические), скобки,
+// запятые, а также ";" и "=" для let-инструкций. Неизвестный символ — ошибка,
+// а не молча пропущенный фрагмент.
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c) || c == '.':
+			end, err := scanNumber(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, expr[i:end])
+			i = end
+		case isIdentStart(c):
+			start := i
+			for i < len(expr) && isIdentChar(expr[i]) {
+				i++
+			}
+			tokens = append(tokens, expr[start:i])
+		case i+1 < len(expr) && isTwoCharOperator(expr[i:i+2]):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case strings.IndexByte("+-*/(),<>!;=", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+// scanNumber находит конец числового литерала, начинающегося в позиции start.
+// Грамматика: десятичные числа с дробной частью и экспонентой (1.5e-3, .5, 5.),
+// целые с префиксом 0x, 0o или 0b, подчёркивания между цифрами (1_000_000)
+// и необязательный суффикс мнимой единицы i.
+func scanNumber(expr string, start int) (int, error) {
+	malformed := fmt.Errorf("malformed number at position %d", start)
+	i := start
+
+	if expr[i] == '0' && i+1 < len(expr) && basePrefix(expr[i+1]) != 0 {
+		base := basePrefix(expr[i+1])
+		i += 2
+		end, ok := scanDigits(expr, i, base)
+		if !ok || end == i {
+			return 0, malformed
+		}
+		i = end
+	} else {
+		end, ok := scanDigits(expr, i, 10)
+		if !ok {
+			return 0, malformed
+		}
+		digits := end - i
+		i = end
+		if i < len(expr) && expr[i] == '.' {
+			end, ok = scanDigits(expr, i+1, 10)
+			if !ok {
+				return 0, malformed
+			}
+			digits += end - i - 1
+			i = end
+		}
+		if digits == 0 {
+			return 0, malformed
+		}
+		if i < len(expr) && (expr[i] == 'e' || expr[i] == 'E') {
+			i++
+			if i < len(expr) && (expr[i] == '+' || expr[i] == '-') {
+				i++
+			}
+			end, ok = scanDigits(expr, i, 10)
+			if !ok || end == i {
+				return 0, malformed
+			}
+			i = end
+		}
+	}
+
+	// Суффикс i превращает число в мнимый литерал: 2i, 0.5i, 1e3i
+	if i < len(expr) && expr[i] == 'i' {
+		i++
+	}
+	if i < len(expr) && (isIdentChar(expr[i]) || expr[i] == '.') {
+		return 0, malformed
+	}
+	return i, nil
+}
+
+// basePrefix возвращает основание для второго символа префикса 0x, 0o или 0b
+func basePrefix(c byte) int {
+	switch c {
+	case 'x', 'X':
+		return 16
+	case 'o', 'O':
+		return 8
+	case 'b', 'B':
+		return 2
+	}
+	return 0
+}
+
+// scanDigits пропускает цифры заданного основания, разрешая одиночные
+// подчёркивания только между цифрами. ok == false при неверном подчёркивании.
+func scanDigits(expr string, i, base int) (end int, ok bool) {
+	start := i
+	for i < len(expr) {
+		switch {
+		case isBaseDigit(expr[i], base):
+			i++
+		case expr[i] == '_':
+			if i == start || i+1 >= len(expr) || !isBaseDigit(expr[i+1], base) {
+				return i, false
+			}
+			i++
+		default:
+			return i, true
+		}
+	}
+	return i, true
+}
+
+func isBaseDigit(c byte, base int) bool {
+	switch base {
+	case 2:
+		return c == '0' || c == '1'
+	case 8:
+		return c >= '0' && c <= '7'
+	case 16:
+		return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+	default:
+		return isDigit(c)
+	}
+}
+
+var errNumberRange = errors.New("number is out of float64 range")
+
+// parseNumber переводит вещественный литерал (без суффикса i) в точную дробь
+// и её float64-приближение. Литералы, не представимые в float64 (переполнение
+// или ненулевое значение, округлённое до нуля), отклоняются.
+func parseNumber(token string) (*big.Rat, float64, error) {
+	clean := strings.ReplaceAll(token, "_", "")
+
+	if len(clean) > 2 && clean[0] == '0' && basePrefix(clean[1]) != 0 {
+		n, ok := new(big.Int).SetString(clean[2:], basePrefix(clean[1]))
+		if !ok {
+			return nil, 0, fmt.Errorf("bad number %q", token)
+		}
+		f, _ := new(big.Float).SetInt(n).Float64()
+		if math.IsInf(f, 0) {
+			return nil, 0, fmt.Errorf("%w: %s", errNumberRange, token)
+		}
+		return new(big.Rat).SetInt(n), f, nil
+	}
+
+	f, err := strconv.ParseFloat(clean, 64)
+	if errors.Is(err, strconv.ErrRange) || f == 0 && strings.ContainsAny(mantissa(clean), "123456789") {
+		return nil, 0, fmt.Errorf("%w: %s", errNumberRange, token)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad number %q", token)
+	}
+	// Значение уже в пределах float64, поэтому показатель ограничен и дробь небольшая
+	r, ok := new(big.Rat).SetString(clean)
+	if !ok {
+		return nil, 0, fmt.Errorf("bad number %q", token)
+	}
+	return r, f, nil
+}
+
+// mantissa возвращает часть десятичного литерала до экспоненты
+func mantissa(s string) string {
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func isTwoCharOperator(s string) bool {
+	switch s {
+	case "<=", ">=", "==", "!=", "&&", "||":
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+// isNumber сообщает, является ли токен вещественным литералом
+func isNumber(s string) bool {
+	return s != "" && (isDigit(s[0]) || s[0] == '.') && !isImaginary(s)
+}
+
+// isImaginary сообщает, является ли токен мнимым литералом вида "2i"
+func isImaginary(s string) bool {
+	return s != "" && (isDigit(s[0]) || s[0] == '.') && strings.HasSuffix(s, "i")
+}
+
+// isIdent сообщает, является ли токен идентификатором (имя функции или константы)
+func isIdent(s string) bool {
+	return s != "" && isIdentStart(s[0])
+}
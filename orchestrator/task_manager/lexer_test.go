@@ -0,0 +1,108 @@
+package task_manager
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumericLiterals(t *testing.T) {
+	cases := map[string]float64{
+		"1e6":         1e6,
+		"1E+3":        1000,
+		"2.5e-3":      0.0025,
+		".5":          0.5,
+		"5.":          5,
+		"0xFF":        255,
+		"0o17":        15,
+		"0b1010":      10,
+		"1_000_000":   1e6,
+		"0b1111_0000": 240,
+	}
+	for input, want := range cases {
+		tokens, err := tokenize(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, []string{input}, tokens, input)
+
+		value, err := parseLiteral(input, models.NumericFloat)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, value.Float64(), input)
+	}
+}
+
+func TestMalformedNumericLiterals(t *testing.T) {
+	for _, input := range []string{"1e", "1e+", "0x", "0b102", "0o8", "1__0", "1_", "0x_ff", "1._5", "1.2.3", "1e5e3", "12abc", ".", "0xFFz"} {
+		_, err := shuntingYard(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestNumericLiteralRange(t *testing.T) {
+	for _, input := range []string{"1e309", "1e-400", "0x1" + strings.Repeat("0", 300)} {
+		_, err := parseLiteral(input, models.NumericFloat)
+		assert.ErrorIs(t, err, errNumberRange, input)
+		_, err = parseLiteral(input, models.NumericRational)
+		assert.ErrorIs(t, err, errNumberRange, input)
+	}
+
+	value, err := parseLiteral("0.1e1", models.NumericRational)
+	assert.NoError(t, err)
+	assert.Equal(t, "1/1", value.String())
+}
+
+func TestLiteralsInExpressions(t *testing.T) {
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("1e3 + 0xFF - 0b1 * 1_000", Options{})
+	assert.NoError(t, err)
+	runAgent(tm)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, 255.0, expr.Result)
+}
+
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range []string{
+		"2 + 3 * (4 - 1)", "1e6", ".5", "0xFF", "0b1010", "0o17", "1_000_000",
+		"sqrt(-4)", "(1+2i)*(3-i)", "if(x >= 10, x * 0.9, x) && !y", "1e", "0x", "1__0",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		tokens, err := tokenize(input)
+		if err != nil {
+			return
+		}
+
+		// Токены — непрерывные куски входа, пропускаются только пробельные символы
+		stripped := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+				return -1
+			}
+			return r
+		}, input)
+		if joined := strings.Join(tokens, ""); joined != stripped {
+			t.Fatalf("tokens %q do not cover input %q", tokens, input)
+		}
+
+		for _, token := range tokens {
+			if token == "" {
+				t.Fatalf("empty token in %q", input)
+			}
+			if !isNumber(token) && !isImaginary(token) {
+				continue
+			}
+			if !unicode.IsDigit(rune(token[0])) && token[0] != '.' {
+				t.Fatalf("number token %q does not start with a digit", token)
+			}
+			// Любое число, принятое лексером, должно разбираться или быть вне диапазона
+			_, err := parseLiteral(token, models.NumericComplex)
+			if err != nil && !strings.Contains(err.Error(), errNumberRange.Error()) {
+				t.Fatalf("lexer accepted %q but parser rejected it: %v", token, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package task_manager
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrExpressionTooLarge = errors.New("expression is too large")
+
+// expressionLimits — ограничения на размер выражения, проверяемые до разбора.
+// Нулевое значение поля снимает ограничение.
+type expressionLimits struct {
+	maxLength int // байт в тексте выражения
+	maxTokens int
+	maxDepth  int // вложенность скобок, включая скобки вызова функций
+	maxTasks  int // задач после оптимизации
+}
+
+// newExpressionLimits читает MAX_EXPRESSION_LENGTH, MAX_EXPRESSION_TOKENS,
+// MAX_EXPRESSION_DEPTH и MAX_EXPRESSION_TASKS
+func newExpressionLimits() expressionLimits {
+	return expressionLimits{
+		maxLength: max(getIntFromEnv("MAX_EXPRESSION_LENGTH", 10000), 0),
+		maxTokens: max(getIntFromEnv("MAX_EXPRESSION_TOKENS", 2000), 0),
+		maxDepth:  max(getIntFromEnv("MAX_EXPRESSION_DEPTH", 100), 0),
+		maxTasks:  max(getIntFromEnv("MAX_EXPRESSION_TASKS", 1000), 0),
+	}
+}
+
+// checkLength отклоняет слишком длинный текст, не разбивая его на токены
+func (l expressionLimits) checkLength(expr string) error {
+	if l.maxLength > 0 && len(expr) > l.maxLength {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed", ErrExpressionTooLarge, len(expr), l.maxLength)
+	}
+	return nil
+}
+
+func (l expressionLimits) checkTokens(tokens []string) error {
+	if l.maxTokens > 0 && len(tokens) > l.maxTokens {
+		return fmt.Errorf("%w: %d tokens, at most %d allowed", ErrExpressionTooLarge, len(tokens), l.maxTokens)
+	}
+	if l.maxDepth == 0 {
+		return nil
+	}
+	depth := 0
+	for _, token := range tokens {
+		switch token {
+		case "(":
+			if depth++; depth > l.maxDepth {
+				return fmt.Errorf("%w: nesting depth exceeds %d", ErrExpressionTooLarge, l.maxDepth)
+			}
+		case ")":
+			depth--
+		}
+	}
+	return nil
+}
+
+func (l expressionLimits) checkTasks(tasks int) error {
+	if l.maxTasks > 0 && tasks > l.maxTasks {
+		return fmt.Errorf("%w: %d tasks, at most %d allowed", ErrExpressionTooLarge, tasks, l.maxTasks)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package task_manager
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpressionLimits(t *testing.T) {
+	t.Setenv("MAX_EXPRESSION_LENGTH", "200")
+	t.Setenv("MAX_EXPRESSION_TOKENS", "50")
+	t.Setenv("MAX_EXPRESSION_DEPTH", "3")
+	tm := NewTaskManager()
+
+	tests := []struct {
+		expr string
+		err  error
+	}{
+		{"((1 + 2) * 3) - sqrt(4)", nil},
+		{"((sqrt(4)))", nil},
+		{"(((sqrt(4))))", ErrExpressionTooLarge},
+		{"(((1)))", nil},
+		{"((((1))))", ErrExpressionTooLarge},
+		{strings.Repeat("1+", 25) + "1", ErrExpressionTooLarge},
+		{strings.Repeat(" ", 200) + "1", ErrExpressionTooLarge},
+		{"((1 +", ErrInvalidExpression},
+	}
+	for _, tt := range tests {
+		_, err := tm.CreateExpression(tt.expr, Options{})
+		if tt.err == nil {
+			assert.NoError(t, err, tt.expr)
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.expr)
+		}
+	}
+}
+
+func TestExpressionLimitsDisabled(t *testing.T) {
+	t.Setenv("MAX_EXPRESSION_TOKENS", "0")
+	t.Setenv("MAX_EXPRESSION_DEPTH", "0")
+	tm := NewTaskManager()
+	_, err := tm.CreateExpression(strings.Repeat("(", 150)+strings.Repeat("1+", 1500)+"1"+strings.Repeat(")", 150), Options{})
+	assert.NoError(t, err)
+}
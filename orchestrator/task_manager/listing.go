@@ -0,0 +1,188 @@
+package task_manager
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrUnknownSort   = errors.New("unknown sort field")
+)
+
+// Поля сортировки списка выражений
+const (
+	SortCreated   = "created_at"
+	SortCompleted = "completed_at"
+)
+
+// expressionStatuses — все статусы выражения
+var expressionStatuses = map[string]bool{"processing": true, "completed": true, "error": true}
+
+// sequence — ID в порядке добавления с быстрым поиском позиции.
+// Курсор страницы — позиция последнего выданного ID, поэтому новые
+// выражения не сдвигают уже выданные страницы.
+type sequence struct {
+	ids []string
+	pos map[string]int
+}
+
+func newSequence() *sequence {
+	return &sequence{pos: make(map[string]int)}
+}
+
+func (s *sequence) add(id string) {
+	if _, exists := s.pos[id]; exists {
+		return
+	}
+	s.pos[id] = len(s.ids)
+	s.ids = append(s.ids, id)
+}
+
+// ExpressionFilter — отбор, сортировка и постраничный вывод выражений
+type ExpressionFilter struct {
+	Status        string // пусто — любой статус
+	Submitter     string // пусто — любой отправитель
+	Owner         string // при ByOwner — только выражения этого владельца (пусто — анонимные)
+	ByOwner       bool
+	CreatedAfter  time.Time // нулевое значение — без ограничения
+	CreatedBefore time.Time // нулевое значение — без ограничения
+	SortBy        string    // SortCreated (по умолчанию) или SortCompleted; во втором случае только завершённые
+	Desc          bool
+	Cursor        string // next_cursor предыдущей страницы
+	Limit         int    // 0 — без ограничения
+}
+
+func (f ExpressionFilter) match(expr models.Expression) bool {
+	return (f.Status == "" || expr.Status == f.Status) &&
+		(f.Submitter == "" || expr.Submitter == f.Submitter) &&
+		(!f.ByOwner || expr.Owner == f.Owner) &&
+		(f.CreatedAfter.IsZero() || expr.CreatedAt.After(f.CreatedAfter)) &&
+		(f.CreatedBefore.IsZero() || expr.CreatedAt.Before(f.CreatedBefore))
+}
+
+// ListExpressions возвращает страницу выражений и курсор следующей страницы
+// (пустой, если после страницы подходящих выражений нет). Выражения берутся
+// в порядке создания или завершения, без обхода задач.
+func (tm *TaskManager) ListExpressions(filter ExpressionFilter) ([]models.Expression, string, error) {
+	if filter.Status != "" && !expressionStatuses[filter.Status] {
+		return nil, "", fmt.Errorf("%w: %q", ErrUnknownStatus, filter.Status)
+	}
+	if filter.SortBy == "" {
+		filter.SortBy = SortCreated
+	}
+
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	var order *sequence
+	switch filter.SortBy {
+	case SortCreated:
+		order = tm.created
+	case SortCompleted:
+		order = tm.finished
+	default:
+		return nil, "", fmt.Errorf("%w: %q", ErrUnknownSort, filter.SortBy)
+	}
+
+	step, i := 1, 0
+	if filter.Desc {
+		step, i = -1, len(order.ids)-1
+	}
+	if filter.Cursor != "" {
+		pos, err := decodeCursor(filter.Cursor, filter.SortBy, order)
+		if err != nil {
+			return nil, "", err
+		}
+		i = pos + step
+	}
+
+	expressions := []models.Expression{}
+	for ; i >= 0 && i < len(order.ids); i += step {
+		expr := tm.expressions[order.ids[i]]
+		if !filter.match(expr) {
+			continue
+		}
+		if filter.Limit > 0 && len(expressions) == filter.Limit {
+			// Курсор нужен, только если за страницей есть ещё подходящее выражение
+			return expressions, encodeCursor(filter.SortBy, expressions[len(expressions)-1].ID), nil
+		}
+		expressions = append(expressions, expr)
+	}
+	return expressions, "", nil
+}
+
+func encodeCursor(sortBy, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortBy + ":" + id))
+}
+
+func decodeCursor(cursor, sortBy string, order *sequence) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	field, id, ok := strings.Cut(string(raw), ":")
+	if !ok || field != sortBy {
+		return 0, fmt.Errorf("%w: it belongs to a listing sorted by another field", ErrInvalidCursor)
+	}
+	pos, exists := order.pos[id]
+	if !exists {
+		return 0, ErrInvalidCursor
+	}
+	return pos, nil
+}
+
+// finishExpression отмечает время завершения выражения (успешного или с ошибкой)
+// и добавляет его в порядок завершения. Вызывается под tm.mu.
+func (tm *TaskManager) finishExpression(expr *models.Expression) {
+	if expr.CompletedAt != nil {
+		return
+	}
+	now := time.Now()
+	expr.CompletedAt = &now
+	tm.finished.add(expr.ID)
+	tm.admission.backlog -= len(tm.exprTasks[expr.ID])
+	slog.Info("expression finished", "expression_id", expr.ID, "status", expr.Status,
+		"error", expr.Error, "duration", now.Sub(expr.CreatedAt))
+	tm.endExpressionSpan(*expr)
+	tm.notify(*expr)
+	if done, ok := tm.done[expr.ID]; ok {
+		close(done)
+		delete(tm.done, expr.ID)
+	}
+}
+
+// WaitExpression ждёт завершения выражения (успешного или с ошибкой) или отмены ctx
+// и возвращает выражение в текущем состоянии. finished == false, если ожидание
+// прервано раньше; ok == false, если выражения нет.
+func (tm *TaskManager) WaitExpression(ctx context.Context, id string) (expr models.Expression, finished, ok bool) {
+	tm.mu.Lock()
+	expr, ok = tm.expressions[id]
+	if !ok || expr.CompletedAt != nil {
+		tm.mu.Unlock()
+		return expr, ok, ok
+	}
+	done, exists := tm.done[id]
+	if !exists {
+		done = make(chan struct{})
+		tm.done[id] = done
+	}
+	tm.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	expr = tm.expressions[id]
+	return expr, expr.CompletedAt != nil, true
+}
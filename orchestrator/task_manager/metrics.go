@@ -0,0 +1,100 @@
+package task_manager
+
+import (
+	"time"
+
+	"github.com/m1tka051209/arithmetic-service/metrics"
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+// taskMetrics — метрики выражений и задач для /metrics
+type taskMetrics struct {
+	registry   *metrics.Registry
+	dispatched *metrics.Counter   // задачи, выданные агентам
+	finished   *metrics.Counter   // принятые результаты агентов
+	duration   *metrics.Histogram // от выдачи задачи до принятия результата
+
+	leaseExpirations *metrics.Counter // задачи, возвращённые в очередь по истечении аренды
+}
+
+func newTaskMetrics(tm *TaskManager) *taskMetrics {
+	r := metrics.NewRegistry()
+	m := &taskMetrics{
+		registry: r,
+		dispatched: r.NewCounter("arithmetic_tasks_dispatched_total",
+			"Tasks handed out to agents, including verification replicas.", "operation"),
+		finished: r.NewCounter("arithmetic_tasks_finished_total",
+			"Task results accepted from agents; outcome is completed or error.", "operation", "outcome"),
+		duration: r.NewHistogram("arithmetic_task_duration_seconds",
+			"Time from handing a task out to accepting its result.", metrics.DefBuckets, "operation"),
+		leaseExpirations: r.NewCounter("arithmetic_task_lease_expirations_total",
+			"Tasks requeued because the agent did not answer within the lease (TASK_LEASE_TIMEOUT).", "operation"),
+	}
+	r.NewGaugeFunc("arithmetic_expressions", "Expressions by status.", []string{"status"}, tm.expressionSamples)
+	r.NewGaugeFunc("arithmetic_task_queue_depth", "Tasks ready to be handed out to agents.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(tm.QueueDepth())}}
+	})
+	r.NewGaugeFunc("arithmetic_task_backlog", "Tasks of expressions still in progress (see MAX_PENDING_TASKS).", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(tm.Admission().Backlog)}}
+	})
+	r.NewGaugeFunc("arithmetic_active_agents", "Agents that asked for a task within AGENT_ACTIVE_WINDOW.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(tm.Admission().ActiveAgents)}}
+	})
+	r.NewCounterFunc("arithmetic_tasks_saved_total", "Tasks not dispatched because identical subexpressions were merged.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(tm.SavedTasks())}}
+	})
+	r.NewCounterFunc("arithmetic_cache_hits_total", "Tasks answered by the result cache or joined to an identical running task.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(tm.CacheStats().Hits)}}
+	})
+	r.NewCounterFunc("arithmetic_cache_misses_total", "Cacheable tasks that had to be dispatched to agents.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(tm.CacheStats().Misses)}}
+	})
+	r.NewGaugeFunc("arithmetic_cache_entries", "Results held in the cache (see RESULT_CACHE_SIZE).", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(tm.CacheStats().Size)}}
+	})
+	return m
+}
+
+// observe учитывает принятый результат задачи или код ошибки вместо него
+func (m *taskMetrics) observe(task models.Task, code string) {
+	outcome := "completed"
+	if code != "" {
+		outcome = "error"
+	}
+	m.finished.Inc(task.Operation, outcome)
+	if task.StartedAt != nil {
+		m.duration.Observe(time.Since(*task.StartedAt).Seconds(), task.Operation)
+	}
+}
+
+func (tm *TaskManager) expressionSamples() []metrics.Sample {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	counts := make(map[string]int, len(expressionStatuses))
+	for _, expr := range tm.expressions {
+		counts[expr.Status]++
+	}
+	samples := make([]metrics.Sample, 0, len(expressionStatuses))
+	for _, status := range []string{"processing", "completed", "error"} {
+		samples = append(samples, metrics.Sample{LabelValues: []string{status}, Value: float64(counts[status])})
+	}
+	return samples
+}
+
+// QueueDepth возвращает число задач, ожидающих агента
+func (tm *TaskManager) QueueDepth() int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	depth := 0
+	for _, id := range tm.queue {
+		if tm.tasks[id].Status == "pending" {
+			depth++
+		}
+	}
+	return depth
+}
+
+// Metrics возвращает реестр метрик выражений и задач
+func (tm *TaskManager) Metrics() *metrics.Registry {
+	return tm.metrics.registry
+}
@@ -0,0 +1,116 @@
+package task_manager
+
+import (
+	"math/big"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+// Оптимизации при построении графа задач. Каждая операция, которую не
+// пришлось отправлять агенту, учитывается в taskBuilder.elided.
+// Отключаются параметром запроса "optimize": false.
+
+// fold вычисляет операцию над литералами на месте, как это сделал бы агент.
+// Если результат — ошибка (переполнение, NaN в режиме "error"),
+// операция не сворачивается: агент вернёт ту же ошибку обычным путём.
+func (b *taskBuilder) fold(op string, arg1, arg2 operand) (operand, bool) {
+	if !b.optimize || arg1.ref != "" || arg2.ref != "" {
+		return operand{}, false
+	}
+	result, code := calculateTask(models.Task{
+		Arg1:            arg1.value,
+		Arg2:            arg2.value,
+		Operation:       op,
+		Numeric:         b.numeric,
+		NonFinite:       b.tm.nonFinite,
+		MaxRationalBits: b.tm.maxRationalBits,
+	})
+	if code != "" {
+		return operand{}, false
+	}
+	b.elided++
+	return operand{value: result, boolean: result.IsBool()}, true
+}
+
+// simplify применяет алгебраические тождества, когда один из аргументов — литерал:
+// x+0, 0+x, x-0, x*1, 1*x и x/1 дают x. Поглощения x*0 нет: вычисление x может
+// завершиться ошибкой (деление на ноль, переполнение), а в режиме ieee
+// бесконечность, умноженная на ноль, даёт NaN. Литеральный x*0 сворачивает fold.
+func (b *taskBuilder) simplify(op string, arg1, arg2 operand) (operand, bool) {
+	if !b.optimize {
+		return operand{}, false
+	}
+	var result operand
+	switch {
+	case op == "+" && arg2.ref == "" && isZero(arg2.value), op == "-" && arg2.ref == "" && isZero(arg2.value):
+		result = arg1
+	case op == "+" && arg1.ref == "" && isZero(arg1.value):
+		result = arg2
+	case (op == "*" || op == "/") && arg2.ref == "" && isOne(arg2.value):
+		result = arg1
+	case op == "*" && arg1.ref == "" && isOne(arg1.value):
+		result = arg2
+	default:
+		return operand{}, false
+	}
+	b.elided++
+	return result, true
+}
+
+// simplifyLogical упрощает x && true и x || false до x
+func (b *taskBuilder) simplifyLogical(op string, cond, branch operand) (operand, bool) {
+	if !b.optimize || branch.ref != "" || branch.value.Bool != (op == "&&") {
+		return operand{}, false
+	}
+	b.elided++
+	return cond, true
+}
+
+// prune удаляет задачи, результат которых больше никому не нужен: ветви,
+// отброшенные по известному условию.
+// Нужны только задачи, достижимые из результата и let-переменных.
+func (b *taskBuilder) prune(roots []operand) {
+	reachable := make(map[string]bool)
+	var visit func(ref string)
+	visit = func(ref string) {
+		i, ok := b.index[ref]
+		if !ok || reachable[ref] {
+			return
+		}
+		reachable[ref] = true
+		for _, dep := range []string{b.tasks[i].Arg1Ref, b.tasks[i].Arg2Ref, b.tasks[i].Arg3Ref} {
+			visit(dep)
+		}
+	}
+	for _, root := range roots {
+		visit(root.ref)
+	}
+
+	kept := b.tasks[:0]
+	for _, task := range b.tasks {
+		switch {
+		case reachable[task.ID]:
+			b.index[task.ID] = len(kept)
+			kept = append(kept, task)
+			continue
+		case task.Status != "skipped":
+			// Пропущенные ветви агентам не ушли бы и без оптимизации
+			b.elided++
+		}
+		delete(b.index, task.ID)
+	}
+	b.tasks = kept
+}
+
+// isOne сообщает, что значение — единица в своём числовом режиме
+func isOne(v models.Value) bool {
+	switch v.Numeric() {
+	case models.NumericRational:
+		return v.Rat.Cmp(big.NewRat(1, 1)) == 0
+	case models.NumericComplex:
+		return v.Complex == 1
+	case models.NumericFloat:
+		return v.Float == 1
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package task_manager
+
+import (
+	"fmt"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+// statement — одна инструкция программы: "let name = expr" или итоговое выражение
+type statement struct {
+	name string   // имя переменной; пусто у итогового выражения
+	rpn  []string // выражение в обратной польской записи
+}
+
+// reserved — имена, которые нельзя занять let-переменной
+var reserved = map[string]bool{"let": true, "true": true, "false": true}
+
+// parseProgram разбирает программу из инструкций, разделённых ";":
+//
+//	let a = 2*3; let b = a + 4; a * b
+//
+// Все инструкции, кроме последней, должны быть let; последняя — выражение,
+// значение которого становится результатом. Завершающая ";" допускается.
+// Программа передаётся уже разбитой на токены (см. tokenize).
+func parseProgram(tokens []string) ([]statement, error) {
+	var (
+		program []statement
+		err     error
+	)
+	defined := make(map[string]bool)
+	for start := 0; start < len(tokens); {
+		end := start
+		for end < len(tokens) && tokens[end] != ";" {
+			end++
+		}
+		part := tokens[start:end]
+		start = end + 1
+
+		if len(part) == 0 {
+			return nil, fmt.Errorf("empty statement")
+		}
+
+		stmt := statement{}
+		if part[0] == "let" {
+			if len(part) < 4 || !isIdent(part[1]) || part[2] != "=" {
+				return nil, fmt.Errorf("let must look like \"let name = expression\"")
+			}
+			stmt.name = part[1]
+			if _, isFunc := functions[stmt.name]; isFunc || reserved[stmt.name] {
+				return nil, fmt.Errorf("%q is a reserved name", stmt.name)
+			}
+			if defined[stmt.name] {
+				return nil, fmt.Errorf("%s is already defined", stmt.name)
+			}
+			part = part[3:]
+		}
+
+		if stmt.rpn, err = toRPN(part); err != nil {
+			return nil, err
+		}
+		if stmt.name != "" {
+			defined[stmt.name] = true
+		}
+		program = append(program, stmt)
+	}
+
+	if len(program) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	for _, stmt := range program[:len(program)-1] {
+		if stmt.name == "" {
+			return nil, fmt.Errorf("only the last statement may be an expression, the rest must be let")
+		}
+	}
+	if program[len(program)-1].name != "" {
+		return nil, fmt.Errorf("program must end with an expression, not let")
+	}
+	return program, nil
+}
+
+// programAST строит синтаксические деревья инструкций программы.
+// Вызывается после успешного построения задач, когда ОПН уже проверена.
+func programAST(program []statement) []models.ASTNode {
+	nodes := make([]models.ASTNode, 0, len(program))
+	for _, stmt := range program {
+		node := astFromRPN(stmt.rpn)
+		if stmt.name != "" {
+			node = models.ASTNode{Type: "let", Value: stmt.name, Args: []models.ASTNode{node}}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func astFromRPN(rpn []string) models.ASTNode {
+	var stack []models.ASTNode
+	pop := func(n int) []models.ASTNode {
+		args := append([]models.ASTNode(nil), stack[len(stack)-n:]...)
+		stack = stack[:len(stack)-n]
+		return args
+	}
+
+	for _, token := range rpn {
+		var node models.ASTNode
+		switch {
+		case isNumber(token) || isImaginary(token):
+			node = models.ASTNode{Type: "number", Value: token}
+		case functions[token] > 0:
+			node = models.ASTNode{Type: "call", Value: token, Args: pop(functions[token])}
+		case isIdent(token):
+			node = models.ASTNode{Type: "name", Value: token}
+		case token == unaryMinus:
+			node = models.ASTNode{Type: "operator", Value: "-", Args: pop(1)}
+		case token == "!":
+			node = models.ASTNode{Type: "operator", Value: token, Args: pop(1)}
+		default:
+			node = models.ASTNode{Type: "operator", Value: token, Args: pop(2)}
+		}
+		stack = append(stack, node)
+	}
+	return stack[0]
+}
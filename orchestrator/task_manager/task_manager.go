@@ -1,20 +1,22 @@
 package task_manager
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
-	"net/http"
 	"os"
-	"regexp"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m1tka051209/arithmetic-service/calc"
 	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
-	// "github.com/m1tka051209/arithmetic-service/orchestrator/api"
 )
 
 const (
@@ -22,45 +24,134 @@ const (
 	charset  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 )
 
-type Handlers struct {
-    tm *TaskManager
+var (
+	ErrTaskNotFound       = errors.New("task not found")
+	ErrTaskCompleted      = errors.New("task already completed")
+	ErrTaskNotInProgress  = errors.New("task is not in progress")
+	ErrResultType         = errors.New("result type does not match task numeric mode")
+	ErrUnknownNumeric     = errors.New("unknown numeric mode")
+	ErrInvalidExpression  = errors.New("invalid expression")
+	ErrDivisionByZero     = errors.New("division by zero")
+	ErrUnknownErrorCode   = errors.New("unknown error code")
+	ErrExpressionNotFound = errors.New("expression not found")
+	ErrUnknownStatus      = errors.New("unknown status")
+)
+
+// taskStatuses — все статусы задачи
+var taskStatuses = map[string]bool{
+	"pending": true, "waiting": true, "in_progress": true, "completed": true,
+	"error": true, "cancelled": true, "deferred": true, "skipped": true,
 }
 
-func (h *Handlers) respondJSON(w http.ResponseWriter, status int, data interface{}) {
-    w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(status)
-    if err := json.NewEncoder(w).Encode(data); err != nil {
-        log.Printf("JSON encode error: %v", err)
-    }
+// errorReasons — описания кодов ошибок агента для models.Expression.Error
+var errorReasons = map[string]string{
+	models.ErrorDivisionByZero:  "division by zero",
+	models.ErrorOverflow:        "overflow",
+	models.ErrorNaN:             "result is not a number",
+	models.ErrorInvalidArgument: "invalid task argument",
+	models.ErrorTooLarge:        "rational result is too large",
+	models.ErrorUnverified:      "agents disagreed on the result",
 }
 
-// respondError отправляет JSON-ответ с ошибкой
-func (h *Handlers) respondError(w http.ResponseWriter, status int, message string) {
-    h.respondJSON(w, status, map[string]string{"error": message})
+// Options — параметры вычисления, передаваемые вместе с выражением
+type Options struct {
+	Numeric    string // models.NumericFloat (по умолчанию), NumericRational или NumericComplex
+	NoOptimize bool   // не сворачивать константы и не упрощать выражение ("optimize": false)
+	NoCache    bool   // не брать результаты из общего кэша и не пополнять его ("cache": false)
+	Submitter  string // кто отправил выражение
+	Owner      string // кому принадлежит выражение; пусто — анонимное
+
+	CallbackURL string // куда отправить выражение после завершения ("callback_url")
+
+	// IdempotencyKey — ключ клиента: повтор с тем же ключом и тем же RequestHash
+	// возвращает уже созданное выражение, с другим — ErrIdempotencyConflict
+	IdempotencyKey string
+	RequestHash    string
 }
 
 type TaskManager struct {
-	expressions   map[string]models.Expression
-	tasks         map[string]models.Task
-	mu            sync.RWMutex
-	idMu          sync.Mutex
-	rand          *rand.Rand
-	operationTime map[string]time.Duration
+	expressions     map[string]models.Expression
+	tasks           map[string]models.Task
+	exprTasks       map[string][]string      // ID выражения -> ID его задач в порядке создания
+	created         *sequence                // выражения в порядке создания
+	finished        *sequence                // выражения в порядке завершения
+	done            map[string]chan struct{} // ID выражения -> канал, закрываемый при его завершении; только для ожидающих
+	dependents      map[string][]string      // ID задачи -> задачи, ожидающие её результат
+	queue           []string                 // задачи, готовые к выполнению, в порядке поступления
+	mu              sync.RWMutex
+	idMu            sync.Mutex
+	rand            *rand.Rand
+	operationTime   map[string]time.Duration
+	nonFinite       string               // models.NonFiniteError или models.NonFiniteIEEE
+	maxRationalBits int                  // предел размера числителя и знаменателя дроби, MAX_RATIONAL_BITS
+	leaseTimeout    time.Duration        // срок аренды задачи сверх времени операции, TASK_LEASE_TIMEOUT
+	leases          map[string]time.Time // ID выданной задачи -> срок её аренды
+	savedTasks      int                  // задачи, не созданные благодаря устранению общих подвыражений
+	cache           *resultCache         // nil, если RESULT_CACHE_SIZE=0
+	idempotency     *idempotencyStore
+	webhooks        *webhookDispatcher
+	verify          *verifier // nil, если проверка результатов выключена
+	limits          expressionLimits
+	admission       *admission
+	metrics         *taskMetrics
+	traces          *taskTraces
 }
 
 func NewTaskManager() *TaskManager {
 	src := rand.NewSource(time.Now().UnixNano())
-	return &TaskManager{
+	tm := &TaskManager{
 		expressions: make(map[string]models.Expression),
 		tasks:       make(map[string]models.Task),
+		exprTasks:   make(map[string][]string),
+		created:     newSequence(),
+		finished:    newSequence(),
+		done:        make(map[string]chan struct{}),
+		dependents:  make(map[string][]string),
 		rand:        rand.New(src),
 		operationTime: map[string]time.Duration{
-			"+": getDurationFromEnv("TIME_ADDITION_MS", 1000),
-			"-": getDurationFromEnv("TIME_SUBTRACTION_MS", 1000),
-			"*": getDurationFromEnv("TIME_MULTIPLICATION_MS", 2000),
-			"/": getDurationFromEnv("TIME_DIVISION_MS", 2000),
+			"+":    getDurationFromEnv("TIME_ADDITION_MS", 1000),
+			"-":    getDurationFromEnv("TIME_SUBTRACTION_MS", 1000),
+			"*":    getDurationFromEnv("TIME_MULTIPLICATION_MS", 2000),
+			"/":    getDurationFromEnv("TIME_DIVISION_MS", 2000),
+			"sqrt": getDurationFromEnv("TIME_SQRT_MS", 2000),
+			"!":    getDurationFromEnv("TIME_LOGICAL_MS", 500),
 		},
+		nonFinite:       getNonFiniteFromEnv("NON_FINITE_RESULTS"),
+		maxRationalBits: getIntFromEnv("MAX_RATIONAL_BITS", calc.DefaultMaxRationalBits),
+		leaseTimeout:    getIntervalFromEnv("TASK_LEASE_TIMEOUT", time.Minute),
+		leases:          make(map[string]time.Time),
+	}
+	tm.idempotency = newIdempotencyStore(getIntervalFromEnv("IDEMPOTENCY_TTL", 24*time.Hour))
+	tm.webhooks = newWebhookDispatcher()
+	tm.verify = newVerifier()
+	tm.limits = newExpressionLimits()
+	tm.admission = newAdmission()
+	tm.metrics = newTaskMetrics(tm)
+	tm.traces = newTaskTraces()
+	if size := getIntFromEnv("RESULT_CACHE_SIZE", 1000); size > 0 {
+		tm.cache = newResultCache(size)
+	}
+	for op := range comparisons {
+		tm.operationTime[op] = getDurationFromEnv("TIME_COMPARISON_MS", 1000)
+	}
+	return tm
+}
+
+// getNonFiniteFromEnv читает режим обработки деления на ноль, переполнения и NaN.
+// По умолчанию такие результаты считаются ошибкой.
+func getNonFiniteFromEnv(envVar string) string {
+	if os.Getenv(envVar) == models.NonFiniteIEEE {
+		return models.NonFiniteIEEE
+	}
+	return models.NonFiniteError
+}
+
+func getIntFromEnv(envVar string, defaultVal int) int {
+	val, err := strconv.Atoi(os.Getenv(envVar))
+	if err != nil {
+		return defaultVal
 	}
+	return val
 }
 
 func getDurationFromEnv(envVar string, defaultVal int) time.Duration {
@@ -72,19 +163,81 @@ func getDurationFromEnv(envVar string, defaultVal int) time.Duration {
 	return time.Duration(val) * time.Millisecond
 }
 
+// getIntervalFromEnv читает длительность в формате Go ("30s", "24h")
+func getIntervalFromEnv(envVar string, defaultVal time.Duration) time.Duration {
+	val, err := time.ParseDuration(os.Getenv(envVar))
+	if err != nil || val <= 0 {
+		return defaultVal
+	}
+	return val
+}
+
+// unaryMinus обозначает унарный минус в ОПН, чтобы не путать его с вычитанием
+const unaryMinus = "~"
+
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5,
+	unaryMinus: 6, "!": 6,
+}
+
+// functions — поддерживаемые функции и число их аргументов
+var functions = map[string]int{"sqrt": 1, "if": 3}
+
 func shuntingYard(expr string) ([]string, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	return toRPN(tokens)
+}
+
+// toRPN переводит токены одного выражения в обратную польскую запись
+func toRPN(tokens []string) ([]string, error) {
 	var output []string
 	var stack []string
-	tokens := regexp.MustCompile(`(-?\d+\.?\d*)|([+\-*/()])`).FindAllString(expr, -1)
-	precedence := map[string]int{"+": 1, "-": 1, "*": 2, "/": 2}
+	// argCounts хранит для каждой открытой скобки число аргументов вызова,
+	// или -1, если скобка группирующая
+	var argCounts []int
+	prev := ""
+
+	for i, token := range tokens {
+		if startsOperand(token) && endsOperand(prev) {
+			return nil, fmt.Errorf("missing operator before %q", token)
+		}
 
-	for _, token := range tokens {
 		switch {
-		case isNumber(token):
+		case isNumber(token) || isImaginary(token):
 			output = append(output, token)
+		case isIdent(token):
+			if _, ok := functions[token]; !ok {
+				if token == "let" {
+					return nil, fmt.Errorf("let is only allowed at the start of a statement")
+				}
+				output = append(output, token)
+				break
+			}
+			if i+1 >= len(tokens) || tokens[i+1] != "(" {
+				return nil, fmt.Errorf("function %s must be called with arguments", token)
+			}
+			stack = append(stack, token)
 		case token == "(":
+			count := -1
+			if len(stack) > 0 && isIdent(stack[len(stack)-1]) {
+				count = 1
+				if i+1 < len(tokens) && tokens[i+1] == ")" {
+					count = 0
+				}
+			}
+			argCounts = append(argCounts, count)
 			stack = append(stack, token)
-		case token == ")":
+		case token == "," || token == ")":
+			if token == "," && !endsOperand(prev) || token == ")" && prev == "," {
+				return nil, fmt.Errorf("missing argument")
+			}
 			for len(stack) > 0 && stack[len(stack)-1] != "(" {
 				output = append(output, stack[len(stack)-1])
 				stack = stack[:len(stack)-1]
@@ -92,14 +245,47 @@ func shuntingYard(expr string) ([]string, error) {
 			if len(stack) == 0 {
 				return nil, fmt.Errorf("mismatched parentheses")
 			}
+			count := argCounts[len(argCounts)-1]
+			if token == "," {
+				if count < 0 {
+					return nil, fmt.Errorf("unexpected comma")
+				}
+				argCounts[len(argCounts)-1]++
+				break
+			}
 			stack = stack[:len(stack)-1]
+			argCounts = argCounts[:len(argCounts)-1]
+			if count >= 0 {
+				fn := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if count != functions[fn] {
+					return nil, fmt.Errorf("function %s expects %d argument(s), got %d", fn, functions[fn], count)
+				}
+				output = append(output, fn)
+			}
+		case (token == "-" || token == "+" || token == "!") && !endsOperand(prev):
+			// Унарный плюс ничего не меняет, унарные минус и отрицание
+			// связываются сильнее бинарных операций
+			switch token {
+			case "-":
+				stack = append(stack, unaryMinus)
+			case "!":
+				stack = append(stack, token)
+			}
 		default:
+			if _, isOperator := precedence[token]; !isOperator {
+				return nil, fmt.Errorf("unexpected %q", token)
+			}
+			if !endsOperand(prev) || token == "!" {
+				return nil, fmt.Errorf("unexpected operator %q", token)
+			}
 			for len(stack) > 0 && precedence[token] <= precedence[stack[len(stack)-1]] && stack[len(stack)-1] != "(" {
 				output = append(output, stack[len(stack)-1])
 				stack = stack[:len(stack)-1]
 			}
 			stack = append(stack, token)
 		}
+		prev = token
 	}
 
 	for len(stack) > 0 {
@@ -112,82 +298,290 @@ func shuntingYard(expr string) ([]string, error) {
 	return output, nil
 }
 
+// startsOperand сообщает, может ли токен начинать операнд
+func startsOperand(token string) bool {
+	return isNumber(token) || isImaginary(token) || isIdent(token) || token == "("
+}
+
+// endsOperand сообщает, может ли токен завершать операнд
+func endsOperand(token string) bool {
+	if _, isFunc := functions[token]; isFunc {
+		return false
+	}
+	return isNumber(token) || isImaginary(token) || isIdent(token) || token == ")"
+}
+
+// ParseExpression разбирает выражение в режиме float и регистрирует его задачи.
+// Оптимизации не применяются: каждая операция выражения становится задачей.
 func (tm *TaskManager) ParseExpression(expr string) ([]models.Task, error) {
-	expr = strings.ReplaceAll(expr, " ", "")
-	rpn, err := shuntingYard(expr)
+	_, tasks, err := tm.createExpression(context.Background(), expr, Options{NoOptimize: true})
+	return tasks, err
+}
+
+// CreateExpression разбирает выражение с заданными параметрами и ставит его задачи в очередь
+func (tm *TaskManager) CreateExpression(expr string, opts Options) (models.Expression, error) {
+	return tm.CreateExpressionContext(context.Background(), expr, opts)
+}
+
+// CreateExpressionContext — CreateExpression в трассе запроса ctx:
+// спан выражения и спаны его задач становятся потомками спана из ctx
+func (tm *TaskManager) CreateExpressionContext(ctx context.Context, expr string, opts Options) (models.Expression, error) {
+	expression, _, err := tm.createExpression(ctx, expr, opts)
+	return expression, err
+}
+
+// BatchItem — выражение пакета с параметрами вычисления
+type BatchItem struct {
+	Expression string
+	Options    Options
+}
+
+// BatchResult — итог создания одного выражения пакета: выражение или ошибка разбора
+type BatchResult struct {
+	Expression models.Expression
+	Err        error
+}
+
+// CreateExpressions разбирает пакет выражений и ставит задачи всех корректных
+// в очередь за один захват блокировки. Результаты идут в порядке items.
+// Место в очереди проверяется для пакета целиком: если задачам всех новых
+// выражений его не хватает, не создаётся ни одно, и возвращается ErrOverloaded.
+func (tm *TaskManager) CreateExpressions(items []BatchItem) ([]BatchResult, error) {
+	return tm.CreateExpressionsContext(context.Background(), items)
+}
+
+// CreateExpressionsContext — CreateExpressions в трассе запроса ctx
+func (tm *TaskManager) CreateExpressionsContext(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	prepared := make([]preparedExpression, len(items))
+	for i, item := range items {
+		prepared[i], results[i].Err = tm.prepareExpression(ctx, item.Expression, item.Options)
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tasks := 0
+	for i := range items {
+		if results[i].Err != nil {
+			continue
+		}
+		// Повторы по ключу идемпотентности места не занимают
+		if _, replayed, err := tm.replay(items[i].Options); err == nil && !replayed {
+			tasks += len(prepared[i].graph.tasks)
+		}
+	}
+	if err := tm.admit(tasks); err != nil {
+		return nil, err
+	}
+	for i := range items {
+		if results[i].Err == nil {
+			results[i].Expression, results[i].Err = tm.commitOnce(prepared[i], items[i].Options)
+		}
+	}
+	return results, nil
+}
+
+func (tm *TaskManager) createExpression(ctx context.Context, expr string, opts Options) (models.Expression, []models.Task, error) {
+	// Повтор запроса с тем же ключом не разбирается заново
+	tm.mu.RLock()
+	id, replayed, err := tm.replay(opts)
+	original := tm.expressions[id]
+	tm.mu.RUnlock()
+	if err != nil || replayed {
+		return original, nil, err
+	}
+
+	p, err := tm.prepareExpression(ctx, expr, opts)
 	if err != nil {
-		return nil, fmt.Errorf("invalid expression: %w", err)
+		return models.Expression{}, nil, err
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	expression, err := tm.commitOnce(p, opts)
+	return expression, p.graph.tasks, err
+}
+
+// commitOnce сохраняет выражение, если запрос с тем же ключом идемпотентности
+// ещё не создал его (например, параллельный повтор). Вызывается под tm.mu.
+func (tm *TaskManager) commitOnce(p preparedExpression, opts Options) (models.Expression, error) {
+	id, replayed, err := tm.replay(opts)
+	if err != nil || replayed {
+		return tm.expressions[id], err
+	}
+	if err := tm.admit(len(p.graph.tasks)); err != nil {
+		return models.Expression{}, err
+	}
+	expression := tm.commitExpression(p)
+	if opts.IdempotencyKey != "" {
+		tm.idempotency.remember(idempotencyKey(opts), opts.RequestHash, expression.ID, time.Now())
+	}
+	return expression, nil
+}
+
+// preparedExpression — разобранное выражение с графом задач, ещё не сохранённое
+type preparedExpression struct {
+	expression models.Expression
+	graph      taskGraph
+	parent     trace.SpanContext // спан запроса, создавшего выражение
+}
+
+// prepareExpression разбирает выражение и строит его задачи. Блокировка
+// tm.mu не нужна: до commitExpression выражение никому не видно.
+func (tm *TaskManager) prepareExpression(ctx context.Context, expr string, opts Options) (p preparedExpression, err error) {
+	parent := trace.SpanContextFromContext(ctx)
+	_, span := tracer().Start(ctx, "parse expression")
+	defer func() {
+		span.SetAttributes(attribute.String("expression.id", p.expression.ID), attribute.Int("expression.tasks", len(p.graph.tasks)))
+		endSpan(span, err)
+	}()
+
+	numeric := opts.Numeric
+	switch numeric {
+	case "":
+		numeric = models.NumericFloat
+	case models.NumericFloat, models.NumericRational, models.NumericComplex:
+	default:
+		return preparedExpression{}, fmt.Errorf("%w: %q", ErrUnknownNumeric, opts.Numeric)
+	}
+
+	if opts.CallbackURL != "" {
+		if err := tm.webhooks.validateCallback(ctx, opts.CallbackURL); err != nil {
+			return preparedExpression{}, err
+		}
+	}
+
+	if err := tm.limits.checkLength(expr); err != nil {
+		return preparedExpression{}, err
+	}
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return preparedExpression{}, fmt.Errorf("%w: %w", ErrInvalidExpression, err)
+	}
+	if err := tm.limits.checkTokens(tokens); err != nil {
+		return preparedExpression{}, err
+	}
+	program, err := parseProgram(tokens)
+	if err != nil {
+		return preparedExpression{}, fmt.Errorf("%w: %w", ErrInvalidExpression, err)
 	}
 
-	var stack []float64
-	var tasks []models.Task
 	exprID := tm.GenerateID()
+	graph, err := tm.buildTasks(exprID, program, numeric, !opts.NoOptimize)
+	if err != nil {
+		return preparedExpression{}, err
+	}
+	if err := tm.limits.checkTasks(len(graph.tasks)); err != nil {
+		return preparedExpression{}, err
+	}
+	for i := range graph.tasks {
+		graph.tasks[i].NoCache = opts.NoCache
+	}
 
-	for _, token := range rpn {
-		if isNumber(token) {
-			num, _ := strconv.ParseFloat(token, 64)
-			stack = append(stack, num)
-		} else {
-			if len(stack) < 2 {
-				return nil, fmt.Errorf("invalid expression")
-			}
-			arg2 := stack[len(stack)-1]
-			arg1 := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
-
-			task := models.Task{
-				ID:            tm.GenerateID(),
-				Arg1:          arg1,
-				Arg2:          arg2,
-				Operation:     token,
-				OperationTime: tm.operationTime[token],
-				Status:        "pending",
-				ExpressionID:  exprID,
-			}
-			tasks = append(tasks, task)
-			stack = append(stack, calculateTask(task))
+	expression := models.Expression{
+		ID:         exprID,
+		Status:     "processing",
+		Numeric:    numeric,
+		SavedTasks: graph.saved,
+		Elided:     graph.elided,
+		AST:         programAST(program),
+		Submitter:   opts.Submitter,
+		Owner:       opts.Owner,
+		CallbackURL: opts.CallbackURL,
+	}
+	if graph.root.ref == "" {
+		// Выражение без операций (например, "5") вычислено сразу
+		expression.Status = "completed"
+		setExpressionResult(&expression, graph.root.value)
+	} else {
+		expression.RootTaskID = graph.root.ref
+	}
+	for name, value := range graph.bindings {
+		if value.ref == "" {
+			expression.Bindings = withBinding(expression.Bindings, name, value.value)
+			continue
 		}
+		if expression.BindingTasks == nil {
+			expression.BindingTasks = make(map[string][]string)
+		}
+		expression.BindingTasks[value.ref] = append(expression.BindingTasks[value.ref], name)
 	}
 
-	if len(stack) != 1 {
-		return nil, fmt.Errorf("invalid expression")
+	return preparedExpression{expression: expression, graph: graph, parent: parent}, nil
+}
+
+// commitExpression сохраняет разобранное выражение и ставит его задачи в очередь.
+// Вызывается под tm.mu.
+func (tm *TaskManager) commitExpression(p preparedExpression) models.Expression {
+	expression := p.expression
+	expression.CreatedAt = time.Now()
+	slog.Info("expression created", "expression_id", expression.ID, "tasks", len(p.graph.tasks), "owner", expression.Owner)
+	tm.startExpressionSpan(p, expression)
+	if expression.Status == "completed" {
+		tm.finishExpression(&expression)
 	}
+	tm.expressions[expression.ID] = expression
+	tm.created.add(expression.ID)
+	// Задачи из кэша завершаются сразу и могут успеть завершить выражение
+	tm.storeTasks(p.graph.tasks)
+	tm.savedTasks += p.graph.saved
 
-	tm.mu.Lock()
-	defer tm.mu.Unlock()
-	tm.expressions[exprID] = models.Expression{
-		ID:     exprID,
-		Status: "processing",
-		Result: stack[0],
+	return tm.expressions[expression.ID]
+}
+
+func setExpressionResult(expr *models.Expression, v models.Value) {
+	expr.Result = v.Float64()
+	switch v.Numeric() {
+	case models.NumericRational:
+		expr.Exact = v.String()
+	case models.NumericComplex:
+		expr.Complex = v.String()
+	case models.KindBool:
+		b := v.Bool
+		expr.Boolean = &b
 	}
+}
 
+// storeTasks сохраняет задачи, связывает зависимости и ставит готовые в очередь.
+// Готовые задачи ставятся в очередь после связывания всех зависимостей:
+// задача, найденная в кэше, завершается сразу и должна застать своих потребителей.
+// Вызывается под tm.mu.
+func (tm *TaskManager) storeTasks(tasks []models.Task) {
 	for _, task := range tasks {
 		tm.tasks[task.ID] = task
+		tm.exprTasks[task.ExpressionID] = append(tm.exprTasks[task.ExpressionID], task.ID)
+		refs := []string{task.Arg1Ref, task.Arg2Ref, task.Arg3Ref}
+		for i, ref := range refs {
+			// (a+b)*(a+b) ссылается на одну задачу дважды — зависимость одна
+			if ref != "" && !slices.Contains(refs[:i], ref) {
+				tm.dependents[ref] = append(tm.dependents[ref], task.ID)
+			}
+		}
+	}
+	// Задачи занимают место в очереди, пока выражение не завершится (см. finishExpression)
+	if len(tasks) > 0 && tm.expressions[tasks[0].ExpressionID].CompletedAt == nil {
+		tm.admission.backlog += len(tasks)
+	}
+	tm.startTaskSpans(tasks)
+	for _, task := range tasks {
+		if task.Status == "pending" {
+			tm.enqueue(task)
+		}
 	}
+}
 
-	return tasks, nil
+// SavedTasks возвращает, сколько задач не было отправлено агентам благодаря
+// устранению общих подвыражений
+func (tm *TaskManager) SavedTasks() int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.savedTasks
 }
 
-// GetAllExpressions возвращает список всех выражений
+// GetAllExpressions возвращает список всех выражений в порядке создания.
+// Статус выражения поддерживается при завершении задач, пересчитывать его не нужно.
 func (tm *TaskManager) GetAllExpressions() []models.Expression {
-    tm.mu.RLock()
-    defer tm.mu.RUnlock()
-
-    expressions := make([]models.Expression, 0, len(tm.expressions))
-    for _, expr := range tm.expressions {
-        // Проверяем статус выражения
-        allTasksCompleted := true
-        for _, task := range tm.tasks {
-            if task.ExpressionID == expr.ID && task.Status != "completed" {
-                allTasksCompleted = false
-                break
-            }
-        }
-        if allTasksCompleted {
-            expr.Status = "completed"
-        }
-        expressions = append(expressions, expr)
-    }
+    expressions, _, _ := tm.ListExpressions(ExpressionFilter{})
     return expressions
 }
 
@@ -201,22 +595,85 @@ func (tm *TaskManager) GetExpressionByID(id string) (models.Expression, bool) {
     return expr, exists
 }
 
-func calculateTask(task models.Task) float64 {
-	switch task.Operation {
-	case "+":
-		return task.Arg1 + task.Arg2
-	case "-":
-		return task.Arg1 - task.Arg2
-	case "*":
-		return task.Arg1 * task.Arg2
-	case "/":
-		if task.Arg2 == 0 {
-			return 0
+// ExpressionGraph возвращает дерево разбора выражения и его задачи
+// в порядке зависимостей
+func (tm *TaskManager) ExpressionGraph(id string) (models.Graph, bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	expr, exists := tm.expressions[id]
+	if !exists {
+		return models.Graph{}, false
+	}
+	// Задачи создаются раньше тех, кто от них зависит, поэтому порядок
+	// создания уже совпадает с порядком зависимостей
+	graph := models.Graph{ExpressionID: id, Root: expr.RootTaskID, AST: expr.AST, Tasks: []models.Task{}}
+	for _, taskID := range tm.exprTasks[id] {
+		graph.Tasks = append(graph.Tasks, tm.tasks[taskID])
+	}
+	return graph, true
+}
+
+// TaskFilter — отбор и постраничный вывод задач выражения
+type TaskFilter struct {
+	Statuses []string // пусто — задачи в любом статусе
+	Offset   int
+	Limit    int // 0 — без ограничения
+}
+
+// ListTasks возвращает задачи выражения в порядке создания, отобранные по статусу,
+// и общее число подходящих задач
+func (tm *TaskManager) ListTasks(exprID string, filter TaskFilter) ([]models.Task, int, error) {
+	wanted := make(map[string]bool, len(filter.Statuses))
+	for _, status := range filter.Statuses {
+		if !taskStatuses[status] {
+			return nil, 0, fmt.Errorf("%w: %q", ErrUnknownStatus, status)
 		}
-		return task.Arg1 / task.Arg2
-	default:
-		return task.Arg1
+		wanted[status] = true
 	}
+
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	if _, exists := tm.expressions[exprID]; !exists {
+		return nil, 0, ErrExpressionNotFound
+	}
+
+	tasks := []models.Task{}
+	total := 0
+	for _, id := range tm.exprTasks[exprID] {
+		task := tm.tasks[id]
+		if len(wanted) > 0 && !wanted[task.Status] {
+			continue
+		}
+		total++
+		if total > filter.Offset && (filter.Limit == 0 || len(tasks) < filter.Limit) {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, total, nil
+}
+
+// GetTask возвращает задачу по ID
+func (tm *TaskManager) GetTask(id string) (models.Task, bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	task, exists := tm.tasks[id]
+	return task, exists
+}
+
+// calculateTask вычисляет задачу так же, как агент: правила общие, из пакета calc.
+// Вместо нечислового результата в режиме "error" возвращается код ошибки.
+func calculateTask(task models.Task) (models.Value, string) {
+	return calc.Calculate(calc.Task{
+		Operation:       task.Operation,
+		Arg1:            task.Arg1,
+		Arg2:            task.Arg2,
+		Numeric:         task.Numeric,
+		NonFinite:       task.NonFinite,
+		MaxRationalBits: task.MaxRationalBits,
+	})
 }
 
 func (tm *TaskManager) GenerateID() string {
@@ -234,93 +691,276 @@ func (tm *TaskManager) SaveExpression(id string, tasks []models.Task) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
-	tm.expressions[id] = models.Expression{
-		ID:     id,
-		Status: "processing",
+	expr := models.Expression{
+		ID:        id,
+		Status:    "processing",
+		Numeric:   models.NumericFloat,
+		CreatedAt: time.Now(),
+	}
+	if len(tasks) > 0 {
+		expr.RootTaskID = tasks[len(tasks)-1].ID
+	} else {
+		expr.Status = "completed"
+		tm.finishExpression(&expr)
 	}
+	tm.expressions[id] = expr
+	tm.created.add(id)
 
+	stored := make([]models.Task, 0, len(tasks))
 	for _, t := range tasks {
+		t.ExpressionID = id
 		t.Status = "pending"
-		tm.tasks[t.ID] = t
+		if t.Arg1Ref != "" || t.Arg2Ref != "" {
+			t.Status = "waiting"
+		}
+		stored = append(stored, t)
 	}
+	tm.storeTasks(stored)
 }
 
+// GetNextTask выдаёт самую старую задачу, все аргументы которой уже известны
 func (tm *TaskManager) GetNextTask() (models.Task, bool) {
+	return tm.GetNextTaskFor("")
+}
+
+// GetNextTaskFor выдаёт следующую задачу агенту agentID и запоминает,
+// кто и когда её взял
+func (tm *TaskManager) GetNextTaskFor(agentID string) (models.Task, bool) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
-
-	for _, task := range tm.tasks {
-		if task.Status == "pending" {
-			task.Status = "in_progress"
-			tm.tasks[task.ID] = task
+	// Агент в карантине не получает задач и не добавляет места в очереди
+	if tm.verify != nil && tm.verify.quarantined(agentID) {
+		return models.Task{}, false
+	}
+	tm.admission.seen(agentID, time.Now())
+	tm.expireLeases(time.Now())
+
+	if tm.verify != nil {
+		tm.expireRounds(time.Now())
+		// Сначала — повторы проверяемых задач, чтобы их результаты не задерживались
+		if task, ok := tm.nextReplica(agentID); ok {
+			tm.traces.task(task.ID).AddEvent("replica dispatched", trace.WithAttributes(attribute.String("agent.id", agentID)))
+			tm.metrics.dispatched.Inc(task.Operation)
 			return task, true
 		}
 	}
+
+	for len(tm.queue) > 0 {
+		id := tm.queue[0]
+		tm.queue = tm.queue[1:]
+
+		task, exists := tm.tasks[id]
+		if !exists || task.Status != "pending" {
+			continue
+		}
+		if tm.expressions[task.ExpressionID].Status == "error" {
+			// Выражение уже завершилось ошибкой — независимые ветви считать незачем
+			task.Status = "cancelled"
+			tm.tasks[id] = task
+			tm.handOff(task)
+			continue
+		}
+		now := time.Now()
+		task.Status = "in_progress"
+		task.AgentID = agentID
+		task.StartedAt = &now
+		tm.tasks[id] = task
+		tm.startVerification(task)
+		tm.lease(task, now)
+		tm.traceDispatch(task, now)
+		tm.metrics.dispatched.Inc(task.Operation)
+		return task, true
+	}
 	return models.Task{}, false
 }
 
-// SaveTaskResult сохраняет результат задачи и возвращает статус
-func (tm *TaskManager) SaveTaskResult(taskID string, result float64) (bool, error) {
+// SaveTaskResult сохраняет результат задачи, передаёт его зависимым задачам
+// и завершает выражение, если это была корневая задача
+func (tm *TaskManager) SaveTaskResult(taskID string, result models.Value) (bool, error) {
+    return tm.SaveTaskResultFrom(taskID, "", result)
+}
+
+// SaveTaskResultFrom — SaveTaskResult от агента agentID. Результат проверяемой
+// задачи принимается, когда совпадут результаты всех её исполнителей.
+func (tm *TaskManager) SaveTaskResultFrom(taskID, agentID string, result models.Value) (bool, error) {
     tm.mu.Lock()
     defer tm.mu.Unlock()
 
     task, exists := tm.tasks[taskID]
     if !exists {
-        return false, fmt.Errorf("task not found") // 404
+        return false, ErrTaskNotFound // 404
     }
-
-    // Валидация результата (пример)
-    if task.Operation == "/" && task.Arg2 == 0 && result == 0 {
-        return false, fmt.Errorf("division by zero") // 422
+    if task.Status == "completed" || task.Status == "error" {
+        return false, ErrTaskCompleted // 409
+    }
+    if task.Status != "in_progress" {
+        return false, ErrTaskNotInProgress // 409
+    }
+    if result.Numeric() != resultKindOf(task) {
+        return false, ErrResultType // 422
     }
 
-    task.Result = result
-    task.Status = "completed"
-    tm.tasks[taskID] = task
+    // Агент без поддержки кодов ошибок может прислать Inf или NaN напрямую
+    s := submission{agentID: agentID, value: result}
+    if task.NonFinite != models.NonFiniteIEEE {
+        s.code = calc.NonFiniteCode(result)
+    }
+    if calc.TooLarge(result, task.MaxRationalBits) {
+        s.code = models.ErrorTooLarge
+    }
+    if err := tm.settle(task, s); err != nil {
+        return false, err
+    }
     return true, nil
 }
 
-func (h *Handlers) SubmitResultHandler(w http.ResponseWriter, r *http.Request) {
-    var req struct {
-        ID     string  `json:"id"`
-        Result float64 `json:"result"`
-    }
+// completeTask сохраняет результат, передаёт его зависимым задачам
+// и завершает выражение, если это была корневая задача. Вызывается под tm.mu.
+func (tm *TaskManager) completeTask(task models.Task, result models.Value) {
+	now := time.Now()
+	task.Result = &result
+	task.Status = "completed"
+	task.CompletedAt = &now
+	tm.tasks[task.ID] = task
+	tm.endTaskSpan(task)
+	tm.resolveDependents(task)
+	tm.settleFollowers(task, result, "")
+
+	expr, ok := tm.expressions[task.ExpressionID]
+	if !ok {
+		return
+	}
+	for _, name := range expr.BindingTasks[task.ID] {
+		expr.Bindings = withBinding(expr.Bindings, name, result)
+	}
+	if expr.RootTaskID == task.ID && expr.Status != "error" {
+		expr.Status = "completed"
+		setExpressionResult(&expr, result)
+		tm.finishExpression(&expr)
+	}
+	tm.expressions[expr.ID] = expr
+}
 
-    // Декодирование с проверкой ошибок
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        h.respondError(w, http.StatusUnprocessableEntity, "invalid JSON format")
-        return
-    }
+// withBinding возвращает копию карты именованных значений с добавленным значением.
+// Карта не изменяется на месте, потому что копии выражения уже могли уйти читателям.
+func withBinding(bindings map[string]models.Value, name string, value models.Value) map[string]models.Value {
+	updated := make(map[string]models.Value, len(bindings)+1)
+	for k, v := range bindings {
+		updated[k] = v
+	}
+	updated[name] = value
+	return updated
+}
 
-    // Валидация входных данных
-    if req.ID == "" {
-        h.respondError(w, http.StatusUnprocessableEntity, "id is required")
-        return
-    }
+// SaveTaskError фиксирует ошибку вычисления задачи (деление на ноль, переполнение, NaN)
+// и переводит её выражение в статус "error"
+func (tm *TaskManager) SaveTaskError(taskID, code string) (bool, error) {
+	return tm.SaveTaskErrorFrom(taskID, "", code)
+}
 
-    // Сохранение результата
-    success, err := h.tm.SaveTaskResult(req.ID, req.Result)
-    if err != nil {
-        switch err.Error() {
-        case "task not found":
-            h.respondError(w, http.StatusNotFound, err.Error())
-        case "division by zero":
-            h.respondError(w, http.StatusUnprocessableEntity, err.Error())
-        default:
-            h.respondError(w, http.StatusInternalServerError, "internal error")
-        }
-        return
-    }
+// SaveTaskErrorFrom — SaveTaskError от агента agentID
+func (tm *TaskManager) SaveTaskErrorFrom(taskID, agentID, code string) (bool, error) {
+	if _, known := errorReasons[code]; !known || code == models.ErrorUnverified {
+		return false, fmt.Errorf("%w: %q", ErrUnknownErrorCode, code)
+	}
 
-    if !success {
-        h.respondError(w, http.StatusInternalServerError, "failed to save result")
-        return
-    }
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
 
-    w.WriteHeader(http.StatusOK)
+	task, exists := tm.tasks[taskID]
+	if !exists {
+		return false, ErrTaskNotFound
+	}
+	if task.Status == "completed" || task.Status == "error" {
+		return false, ErrTaskCompleted
+	}
+	if task.Status != "in_progress" {
+		return false, ErrTaskNotInProgress
+	}
+	if err := tm.settle(task, submission{agentID: agentID, code: code}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// failTask помечает задачу ошибкой, отменяет зависящие от неё задачи
+// и завершает выражение с причиной. Вызывается под tm.mu.
+func (tm *TaskManager) failTask(task models.Task, code string) {
+	now := time.Now()
+	task.Status = "error"
+	task.Error = code
+	task.CompletedAt = &now
+	tm.tasks[task.ID] = task
+	tm.endTaskSpan(task)
+	tm.cancelDependents(task.ID)
+	tm.settleFollowers(task, models.Value{}, code)
+
+	if expr, ok := tm.expressions[task.ExpressionID]; ok && expr.Status != "error" {
+		expr.Status = "error"
+		expr.Error = errorReasons[code]
+		tm.finishExpression(&expr)
+		tm.expressions[expr.ID] = expr
+	}
 }
 
-func isNumber(s string) bool {
-	_, err := strconv.ParseFloat(s, 64)
-	return err == nil
-}
\ No newline at end of file
+func (tm *TaskManager) cancelDependents(taskID string) {
+	for _, id := range tm.dependents[taskID] {
+		task := tm.tasks[id]
+		if task.Status == "waiting" {
+			task.Status = "cancelled"
+			tm.tasks[id] = task
+			tm.cancelDependents(id)
+		}
+	}
+	delete(tm.dependents, taskID)
+}
+
+// resolveDependents подставляет результат задачи в ожидающие её задачи
+// и ставит в очередь те, у которых больше нет незавершённых зависимостей
+func (tm *TaskManager) resolveDependents(done models.Task) {
+	for _, id := range tm.dependents[done.ID] {
+		task := tm.tasks[id]
+		if task.Arg1Ref == done.ID {
+			task.Arg1 = *done.Result
+		}
+		if task.Arg2Ref == done.ID {
+			task.Arg2 = *done.Result
+		}
+		if task.Arg3Ref == done.ID {
+			task.Arg3 = done.Result
+		}
+		if isControl(task.Operation) {
+			tm.tasks[id] = task
+			if task.Status == "waiting" {
+				tm.advanceControl(task)
+			}
+			continue
+		}
+		if task.Status == "waiting" && tm.isResolved(task.Arg1Ref) && tm.isResolved(task.Arg2Ref) {
+			task.Status = "pending"
+			tm.tasks[id] = task
+			tm.enqueue(task)
+			continue
+		}
+		tm.tasks[id] = task
+	}
+	delete(tm.dependents, done.ID)
+}
+
+// resultKindOf возвращает ожидаемый вид результата задачи: сравнения и отрицание
+// дают логическое значение, остальные операции — число в режиме задачи
+// (задачи без режима считаются float)
+func resultKindOf(task models.Task) string {
+	switch {
+	case comparisons[task.Operation] || task.Operation == "!":
+		return models.KindBool
+	case task.Numeric == "":
+		return models.NumericFloat
+	default:
+		return task.Numeric
+	}
+}
+
+func (tm *TaskManager) isResolved(ref string) bool {
+	return ref == "" || tm.tasks[ref].Status == "completed"
+}
@@ -1,8 +1,12 @@
 package task_manager
 
 import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/big"
 	"testing"
-	// "time"
+	"time"
 
 	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
 	"github.com/stretchr/testify/assert"
@@ -21,9 +25,7 @@ func TestExpressionCompletion(t *testing.T) {
 	tasks, err := tm.ParseExpression("(5 + 3) * 2")
 	assert.NoError(t, err)
 
-	for _, task := range tasks {
-		tm.SaveTaskResult(task.ID, calculateTask(task))
-	}
+	runAgent(tm)
 
 	expr, exists := tm.GetExpressionByID(tasks[0].ExpressionID)
 	assert.True(t, exists)
@@ -31,6 +33,101 @@ func TestExpressionCompletion(t *testing.T) {
 	assert.Equal(t, 16.0, expr.Result)
 }
 
+// runAgent выполняет задачи так же, как агент: берёт готовые и возвращает результат.
+// Возвращает операции в порядке их выдачи.
+func runAgent(tm *TaskManager) []string {
+	var dispatched []string
+	for {
+		task, ok := tm.GetNextTask()
+		if !ok {
+			return dispatched
+		}
+		dispatched = append(dispatched, task.Operation)
+		if result, code := calculateTask(task); code != "" {
+			tm.SaveTaskError(task.ID, code)
+		} else {
+			tm.SaveTaskResult(task.ID, result)
+		}
+	}
+}
+
+func TestDependentTaskWaitsForArguments(t *testing.T) {
+	tm := NewTaskManager()
+	tasks, err := tm.ParseExpression("(5 + 3) * 2")
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, tasks[0].ID, tasks[1].Arg1Ref)
+
+	first, ok := tm.GetNextTask()
+	assert.True(t, ok)
+	assert.Equal(t, "+", first.Operation)
+
+	_, ok = tm.GetNextTask()
+	assert.False(t, ok, "multiplication must wait for the sum")
+
+	tm.SaveTaskResult(first.ID, models.Float(8))
+	second, ok := tm.GetNextTask()
+	assert.True(t, ok)
+	assert.Equal(t, 8.0, second.Arg1.Float64())
+}
+
+func TestRationalMode(t *testing.T) {
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("1/3 + 1/6", Options{Numeric: models.NumericRational, NoOptimize: true})
+	assert.NoError(t, err)
+
+	task, ok := tm.GetNextTask()
+	assert.True(t, ok)
+	assert.Equal(t, models.NumericRational, task.Numeric)
+	assert.Equal(t, "/", task.Operation)
+	assert.Equal(t, "3/1", task.Arg2.String())
+
+	_, err = tm.SaveTaskResult(task.ID, models.Float(0.33))
+	assert.ErrorIs(t, err, ErrResultType)
+	result, _ := calculateTask(task)
+	tm.SaveTaskResult(task.ID, result)
+	runAgent(tm)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, "1/2", expr.Exact)
+	assert.Equal(t, 0.5, expr.Result)
+}
+
+// Повторное возведение в квадрат не раздувает дробь без предела:
+// свёртка останавливается, а задача завершается ошибкой too_large
+func TestRationalSizeLimit(t *testing.T) {
+	tm := NewTaskManager()
+	tm.maxRationalBits = 64
+	// (3/7)^16 ещё помещается в 64 бита и сворачивается, (3/7)^32 — уже нет
+	expr, err := tm.CreateExpression("let a = 3/7; let b = a*a; let c = b*b; let d = c*c; let e = d*d; e*e",
+		Options{Numeric: models.NumericRational})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*"}, runAgent(tm))
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, "rational result is too large", expr.Error)
+
+	// Слишком большую дробь от агента, не знающего о пределе, оркестратор не примет
+	expr, err = tm.CreateExpression("1/3 + 1", Options{Numeric: models.NumericRational, NoOptimize: true})
+	assert.NoError(t, err)
+	task, _ := tm.GetNextTask()
+	huge := new(big.Rat).SetFrac(new(big.Int).Lsh(big.NewInt(1), 100), big.NewInt(3))
+	_, err = tm.SaveTaskResult(task.ID, models.Rational(huge))
+	assert.NoError(t, err)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, "rational result is too large", expr.Error)
+}
+
+func TestUnknownNumericMode(t *testing.T) {
+	tm := NewTaskManager()
+	_, err := tm.CreateExpression("1 + 2", Options{Numeric: "octonion"})
+	assert.ErrorIs(t, err, ErrUnknownNumeric)
+}
+
 func TestDivisionByZero(t *testing.T) {
 	tm := NewTaskManager()
 	_, err := tm.ParseExpression("5 / 0")
@@ -58,14 +155,14 @@ func TestTaskManagement(t *testing.T) {
 	tasksToSave := []models.Task{
 		{
 			ID:        tm.GenerateID(),
-			Arg1:      2,
-			Arg2:      3,
+			Arg1:      models.Float(2),
+			Arg2:      models.Float(3),
 			Operation: "+",
 		},
 		{
 			ID:        tm.GenerateID(),
-			Arg1:      4,
-			Arg2:      5,
+			Arg1:      models.Float(4),
+			Arg2:      models.Float(5),
 			Operation: "*",
 		},
 	}
@@ -77,7 +174,7 @@ func TestTaskManagement(t *testing.T) {
 	assert.True(t, exists)
 	assert.Equal(t, "+", task.Operation)
 
-	tm.SaveTaskResult(task.ID, 5.0)
+	tm.SaveTaskResult(task.ID, models.Float(5))
 	updatedTask, exists := tm.tasks[task.ID]
 	assert.True(t, exists)
 	assert.Equal(t, "completed", updatedTask.Status)
@@ -85,4 +182,729 @@ func TestTaskManagement(t *testing.T) {
 	task2, exists := tm.GetNextTask()
 	assert.True(t, exists)
 	assert.Equal(t, "*", task2.Operation)
-}
\ No newline at end of file
+}
+
+func TestComplexMode(t *testing.T) {
+	cases := map[string]string{
+		"sqrt(-4)":         "0+2i",
+		"(1+2i)*(3-i)":     "5+5i",
+		"-(2i) + 1":        "1-2i",
+		"sqrt(2i * 2) / 2": "0.7071067811865476+0.7071067811865476i",
+	}
+	for input, want := range cases {
+		tm := NewTaskManager()
+		expr, err := tm.CreateExpression(input, Options{Numeric: models.NumericComplex})
+		assert.NoError(t, err, input)
+
+		runAgent(tm)
+
+		expr, _ = tm.GetExpressionByID(expr.ID)
+		assert.Equal(t, "completed", expr.Status, input)
+		assert.Equal(t, want, expr.Complex, input)
+	}
+}
+
+func TestComplexIsOptIn(t *testing.T) {
+	tm := NewTaskManager()
+	_, err := tm.CreateExpression("2i + 1", Options{})
+	assert.ErrorContains(t, err, "requires numeric mode")
+	_, err = tm.CreateExpression("i * 2", Options{})
+	assert.ErrorContains(t, err, "unknown identifier")
+}
+
+func TestShuntingYardRejectsMalformedInput(t *testing.T) {
+	for _, expr := range []string{"2 3", "2 + * 4", "sqrt 4", "sqrt(1, 2)", "(1, 2)", "2 $ 3", "(1 + 2"} {
+		_, err := shuntingYard(expr)
+		assert.Error(t, err, expr)
+	}
+
+	rpn, err := shuntingYard("-2 * 3 - -1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "~", "3", "*", "1", "~", "-"}, rpn)
+}
+
+func TestRuntimeDivisionByZeroFailsExpression(t *testing.T) {
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("(1 / (2 - 2)) * 3 + 4", Options{NoOptimize: true})
+	assert.NoError(t, err)
+
+	runAgent(tm)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, "division by zero", expr.Error)
+	for _, task := range tm.tasks {
+		switch task.Operation {
+		case "/":
+			assert.Equal(t, "error", task.Status)
+			assert.Equal(t, models.ErrorDivisionByZero, task.Error)
+		case "*", "+":
+			assert.Equal(t, "cancelled", task.Status)
+		}
+	}
+}
+
+func TestNonFiniteResultsAreErrors(t *testing.T) {
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("sqrt(0 - 1)", Options{NoOptimize: true})
+	assert.NoError(t, err)
+	runAgent(tm)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, "result is not a number", expr.Error)
+
+	// Агент без кодов ошибок присылает Inf напрямую
+	expr, _ = tm.CreateExpression("1000 * 10", Options{NoOptimize: true})
+	task, _ := tm.GetNextTask()
+	_, err = tm.SaveTaskResult(task.ID, models.Float(math.Inf(1)))
+	assert.NoError(t, err)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, "overflow", expr.Error)
+
+	_, err = tm.SaveTaskError(task.ID, "bad_luck")
+	assert.ErrorIs(t, err, ErrUnknownErrorCode)
+}
+
+func TestIEEENonFiniteMode(t *testing.T) {
+	tm := NewTaskManager()
+	tm.nonFinite = models.NonFiniteIEEE
+
+	expr, err := tm.CreateExpression("-1 / 0", Options{})
+	assert.NoError(t, err)
+	runAgent(tm)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	assert.True(t, math.IsInf(expr.Result, -1))
+
+	data, err := json.Marshal(expr)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"result":"-Inf"`)
+
+	// У дробей бесконечности нет даже в режиме IEEE
+	_, err = tm.CreateExpression("1 / 0", Options{Numeric: models.NumericRational})
+	assert.ErrorIs(t, err, ErrDivisionByZero)
+}
+
+func TestConditionalExpressions(t *testing.T) {
+	cases := []struct {
+		input      string
+		result     float64
+		dispatched []string
+	}{
+		{"if(12 > 10, 12 * 0.9, 12)", 10.8, []string{">", "*"}},
+		{"if(1 > 2, 100 / 7, 3 + 4)", 7, []string{">", "+"}},
+		{"if(true, 1 + 1, 2 * 2)", 2, []string{"+"}},
+		{"if(1 < 2, if(3 > 4, 10 + 1, 20 + 2), 30 + 3)", 22, []string{"<", ">", "+"}},
+		{"if(1 < 2 || 1 / 0 > 0, 5, 6)", 5, []string{"<"}},
+	}
+	for _, c := range cases {
+		tm := NewTaskManager()
+		tm.nonFinite = models.NonFiniteIEEE
+		expr, err := tm.CreateExpression(c.input, Options{NoOptimize: true})
+		assert.NoError(t, err, c.input)
+
+		assert.Equal(t, c.dispatched, runAgent(tm), c.input)
+
+		expr, _ = tm.GetExpressionByID(expr.ID)
+		assert.Equal(t, "completed", expr.Status, c.input)
+		assert.InDelta(t, c.result, expr.Result, 1e-9, c.input)
+	}
+}
+
+// Деление на ноль в ветви, которая не выполняется, не ошибка
+func TestDivisionByZeroInSkippedBranch(t *testing.T) {
+	cases := []struct {
+		input   string
+		result  float64
+		boolean bool
+	}{
+		{"let d = 0; if(d != 0, 10/d, 0)", 0, false},
+		{"let d = 0; d != 0 && 10/d > 1", 0, true},
+		{"if(1 > 2, 1/0, 5)", 5, false},
+	}
+	for _, c := range cases {
+		for _, noOptimize := range []bool{false, true} {
+			tm := NewTaskManager()
+			expr, err := tm.CreateExpression(c.input, Options{NoOptimize: noOptimize})
+			if !assert.NoError(t, err, c.input) {
+				continue
+			}
+			assert.NotContains(t, runAgent(tm), "/", c.input)
+
+			expr, _ = tm.GetExpressionByID(expr.ID)
+			assert.Equal(t, "completed", expr.Status, c.input)
+			if c.boolean {
+				assert.False(t, *expr.Boolean, c.input)
+			} else {
+				assert.Equal(t, c.result, expr.Result, c.input)
+			}
+		}
+	}
+
+	// Выполняемое деление на ноль по-прежнему отклоняется сразу
+	tm := NewTaskManager()
+	for _, input := range []string{"let d = 0; 10/d", "if(1 < 2, 1/0, 5)", "let x = 1/0; if(1 > 2, x, 5)"} {
+		_, err := tm.CreateExpression(input, Options{})
+		assert.ErrorIs(t, err, ErrDivisionByZero, input)
+	}
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("5 >= 3 && 4 != 0", Options{NoOptimize: true})
+	assert.NoError(t, err)
+	runAgent(tm)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	data, _ := json.Marshal(expr)
+	assert.Contains(t, string(data), `"result":true`)
+
+	expr, _ = tm.CreateExpression("1 > 2 && 5 * 5 > 3", Options{NoOptimize: true})
+	assert.Equal(t, []string{">"}, runAgent(tm), "right operand must not be dispatched")
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	data, _ = json.Marshal(expr)
+	assert.Contains(t, string(data), `"result":false`)
+
+	expr, _ = tm.CreateExpression("!(1/3 == 2/6)", Options{Numeric: models.NumericRational, NoOptimize: true})
+	runAgent(tm)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.False(t, *expr.Boolean)
+}
+
+func TestBooleanTypeErrors(t *testing.T) {
+	tm := NewTaskManager()
+	for _, input := range []string{"1 + (2 > 1)", "if(1, 2, 3)", "if(true, 1, false)", "1 && true", "(1 < 2) < 3", "!5"} {
+		_, err := tm.CreateExpression(input, Options{})
+		assert.ErrorIs(t, err, ErrInvalidExpression, input)
+	}
+	_, err := tm.CreateExpression("1i < 2i", Options{Numeric: models.NumericComplex})
+	assert.ErrorContains(t, err, "not defined for complex numbers")
+}
+
+func TestLetBindings(t *testing.T) {
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("let a = 2*3; let b = a + 4; a * b", Options{NoOptimize: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*", "+", "*"}, runAgent(tm), "a must be computed once")
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, 60.0, expr.Result)
+	assert.Equal(t, 6.0, expr.Bindings["a"].Float64())
+	assert.Equal(t, 10.0, expr.Bindings["b"].Float64())
+
+	expr, err = tm.CreateExpression("let x = 5; let big = x > 3; if(big, x * 2, x)", Options{NoOptimize: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{">", "*"}, runAgent(tm))
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, 10.0, expr.Result)
+	assert.Equal(t, 5.0, expr.Bindings["x"].Float64())
+	assert.True(t, expr.Bindings["big"].Bool)
+}
+
+func TestLetBindingErrors(t *testing.T) {
+	tm := NewTaskManager()
+	for _, input := range []string{
+		"let a = 1; let a = 2; a",
+		"let sqrt = 4; sqrt",
+		"let true = 1; 2",
+		"let a = 1",
+		"let a 1; a",
+		"let a = 1;; a",
+		"2; 3",
+		"a + 1; let a = 1",
+		"1 + let",
+	} {
+		_, err := tm.CreateExpression(input, Options{})
+		assert.ErrorIs(t, err, ErrInvalidExpression, input)
+	}
+}
+
+func TestCommonSubexpressions(t *testing.T) {
+	cases := []struct {
+		input      string
+		result     float64
+		saved      int
+		dispatched []string
+	}{
+		{"(2+3)*(2+3) + (3+2)", 30, 2, []string{"+", "*", "+"}},
+		{"if(1 > 2, 5*5, 0) + 5*5", 25, 1, []string{">", "*", "+"}},
+		{"if(true, 1, 2*2) + 2*2", 5, 1, []string{"*", "+"}},
+		{"2 - 3 + (3 - 2)", 0, 0, []string{"-", "-", "+"}},
+	}
+	total := 0
+	tm := NewTaskManager()
+	for _, c := range cases {
+		expr, err := tm.CreateExpression(c.input, Options{NoOptimize: true, NoCache: true})
+		assert.NoError(t, err, c.input)
+		assert.Equal(t, c.saved, expr.SavedTasks, c.input)
+		total += c.saved
+
+		assert.Equal(t, c.dispatched, runAgent(tm), c.input)
+		expr, _ = tm.GetExpressionByID(expr.ID)
+		assert.Equal(t, "completed", expr.Status, c.input)
+		assert.Equal(t, c.result, expr.Result, c.input)
+	}
+	assert.Equal(t, total, tm.SavedTasks())
+}
+
+func TestConstantFolding(t *testing.T) {
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("2 + 3 * 4", Options{})
+	assert.NoError(t, err)
+	assert.Empty(t, runAgent(tm), "constant expression must not reach agents")
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, 14.0, expr.Result)
+	assert.Equal(t, 2, expr.Elided)
+
+	expr, err = tm.CreateExpression("2 + 3 * 4", Options{NoOptimize: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*", "+"}, runAgent(tm))
+	assert.Zero(t, expr.Elided)
+
+	// Переполнение не сворачивается: ошибку вернёт агент
+	expr, _ = tm.CreateExpression("1e308 * 10", Options{})
+	assert.Equal(t, []string{"*"}, runAgent(tm))
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "overflow", expr.Error)
+}
+
+func TestMultiplicationByZeroKeepsErrors(t *testing.T) {
+	tm := NewTaskManager()
+	for _, input := range []string{"(1/0)*0", "0 * (1/0)"} {
+		_, err := tm.CreateExpression(input, Options{})
+		assert.ErrorIs(t, err, ErrDivisionByZero, input)
+	}
+
+	expr, err := tm.CreateExpression("(1e308 * 10) * 0", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*"}, runAgent(tm), "the failing factor is still computed")
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "overflow", expr.Error)
+
+	// В режиме ieee бесконечность, умноженная на ноль, — NaN, а не ноль
+	tm.nonFinite = models.NonFiniteIEEE
+	expr, err = tm.CreateExpression("(1/0)*0", Options{})
+	assert.NoError(t, err)
+	runAgent(tm)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	assert.True(t, math.IsNaN(expr.Result))
+}
+
+func TestAlgebraicSimplification(t *testing.T) {
+	tm := NewTaskManager()
+	newBuilder := func(numeric string) *taskBuilder {
+		return &taskBuilder{tm: tm, numeric: numeric, optimize: true, index: make(map[string]int), nodes: make(map[string]string), eager: make(map[string]bool)}
+	}
+	lit := func(f float64) operand { return operand{value: models.Float(f)} }
+
+	b := newBuilder(models.NumericFloat)
+	x := b.task("-", lit(7), lit(2))
+	for _, c := range []struct {
+		op         string
+		arg1, arg2 operand
+	}{
+		{"+", x, lit(0)}, {"+", lit(0), x}, {"-", x, lit(0)},
+		{"*", x, lit(1)}, {"*", lit(1), x}, {"/", x, lit(1)},
+	} {
+		result, err := b.binary(c.op, c.arg1, c.arg2)
+		assert.NoError(t, err)
+		assert.Equal(t, x, result, c.op)
+	}
+	assert.Len(t, b.tasks, 1)
+	assert.Equal(t, 6, b.elided)
+
+	// x*0 не поглощает x: его вычисление может завершиться ошибкой
+	b = newBuilder(models.NumericRational)
+	x = b.task("+", operand{value: models.Rational(big.NewRat(1, 3))}, operand{value: models.Rational(big.NewRat(1, 6))})
+	x = b.task("*", x, x)
+	result, err := b.binary("*", operand{value: zero(models.NumericRational)}, x)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.ref)
+	b.prune([]operand{result})
+	assert.Len(t, b.tasks, 3)
+	assert.Zero(t, b.elided)
+
+	b = newBuilder(models.NumericFloat)
+	cond := b.task(">", lit(2), lit(1))
+	cond.boolean = true
+	result, err = b.control("&&", cond, operand{value: models.Bool(true), boolean: true})
+	assert.NoError(t, err)
+	assert.Equal(t, cond, result)
+	result, err = b.control("||", cond, operand{value: models.Bool(false), boolean: true})
+	assert.NoError(t, err)
+	assert.Equal(t, cond, result)
+}
+
+func TestResultCache(t *testing.T) {
+	tm := NewTaskManager()
+	opts := Options{NoOptimize: true}
+
+	tm.CreateExpression("2 * 3", opts)
+	assert.Equal(t, []string{"*"}, runAgent(tm))
+	expr, _ := tm.CreateExpression("2 * 3 + 1", opts)
+	assert.Equal(t, []string{"+"}, runAgent(tm), "2 * 3 must come from the cache")
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, 7.0, expr.Result)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Size: 2, Capacity: 1000}, tm.CacheStats())
+
+	expr, _ = tm.CreateExpression("2 * 3", Options{NoOptimize: true, NoCache: true})
+	assert.Equal(t, []string{"*"}, runAgent(tm), "opted-out expression must be dispatched")
+	assert.Equal(t, 1, tm.CacheStats().Hits)
+}
+
+func TestResultCacheSingleFlight(t *testing.T) {
+	tm := NewTaskManager()
+	opts := Options{NoOptimize: true}
+
+	first, _ := tm.CreateExpression("7 * 8", opts)
+	second, _ := tm.CreateExpression("8 * 7", opts)
+	assert.Equal(t, []string{"*"}, runAgent(tm))
+	for _, id := range []string{first.ID, second.ID} {
+		expr, _ := tm.GetExpressionByID(id)
+		assert.Equal(t, "completed", expr.Status)
+		assert.Equal(t, 56.0, expr.Result)
+	}
+
+	// Ошибка выполняемой задачи достаётся и присоединённым
+	first, _ = tm.CreateExpression("1e308 * 10", opts)
+	second, _ = tm.CreateExpression("1e308 * 10", opts)
+	assert.Equal(t, []string{"*"}, runAgent(tm))
+	for _, id := range []string{first.ID, second.ID} {
+		expr, _ := tm.GetExpressionByID(id)
+		assert.Equal(t, "overflow", expr.Error)
+	}
+
+	// Выражение первой задачи упало — вычисление переходит ко второй
+	first, _ = tm.CreateExpression("(2 - 2) + 5 * 5", opts)
+	second, _ = tm.CreateExpression("5 * 5", opts)
+	task, _ := tm.GetNextTask()
+	tm.SaveTaskError(task.ID, models.ErrorOverflow)
+	assert.Equal(t, []string{"*"}, runAgent(tm))
+	expr, _ := tm.GetExpressionByID(second.ID)
+	assert.Equal(t, 25.0, expr.Result)
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	c := newResultCache(2)
+	c.put("a", models.Float(1))
+	c.put("b", models.Float(2))
+	c.get("a")
+	c.put("c", models.Float(3))
+
+	_, ok := c.get("b")
+	assert.False(t, ok, "least recently used entry must be evicted")
+	for _, key := range []string{"a", "c"} {
+		_, ok := c.get(key)
+		assert.True(t, ok, key)
+	}
+}
+
+func TestExpressionGraph(t *testing.T) {
+	tm := NewTaskManager()
+	expr, err := tm.CreateExpression("let a = 2 * 3; -a + sqrt(4)", Options{NoOptimize: true, NoCache: true})
+	assert.NoError(t, err)
+	task, _ := tm.GetNextTaskFor("agent-1")
+	result, _ := calculateTask(task)
+	tm.SaveTaskResult(task.ID, result)
+
+	graph, ok := tm.ExpressionGraph(expr.ID)
+	assert.True(t, ok)
+	assert.Equal(t, []models.ASTNode{
+		{Type: "let", Value: "a", Args: []models.ASTNode{
+			{Type: "operator", Value: "*", Args: []models.ASTNode{{Type: "number", Value: "2"}, {Type: "number", Value: "3"}}},
+		}},
+		{Type: "operator", Value: "+", Args: []models.ASTNode{
+			{Type: "operator", Value: "-", Args: []models.ASTNode{{Type: "name", Value: "a"}}},
+			{Type: "call", Value: "sqrt", Args: []models.ASTNode{{Type: "number", Value: "4"}}},
+		}},
+	}, graph.AST)
+
+	assert.Len(t, graph.Tasks, 4)
+	position := make(map[string]int)
+	for i, task := range graph.Tasks {
+		position[task.ID] = i
+		for _, ref := range []string{task.Arg1Ref, task.Arg2Ref} {
+			if ref != "" {
+				assert.Less(t, position[ref], i, "dependencies must come first")
+				assert.Contains(t, position, ref)
+			}
+		}
+	}
+	assert.Equal(t, graph.Tasks[3].ID, graph.Root)
+
+	done := graph.Tasks[position[task.ID]]
+	assert.Equal(t, "completed", done.Status)
+	assert.Equal(t, "agent-1", done.AgentID)
+	assert.NotNil(t, done.StartedAt)
+	assert.NotNil(t, done.CompletedAt)
+
+	dot := graph.DOT()
+	assert.Contains(t, dot, `"`+task.ID+`" -> "`)
+	assert.Contains(t, dot, `2 * 3\ncompleted = 6\nagent agent-1`)
+	assert.Contains(t, dot, "peripheries=2")
+
+	_, ok = tm.ExpressionGraph("missing")
+	assert.False(t, ok)
+}
+
+func TestListTasks(t *testing.T) {
+	tm := NewTaskManager()
+	expr, _ := tm.CreateExpression("(1 + 2) * (3 + 4) - 5", Options{NoOptimize: true, NoCache: true})
+	tm.CreateExpression("6 * 7", Options{NoOptimize: true, NoCache: true})
+
+	tasks, total, err := tm.ListTasks(expr.ID, TaskFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, total)
+	var ops []string
+	for _, task := range tasks {
+		assert.Equal(t, expr.ID, task.ExpressionID)
+		ops = append(ops, task.Operation)
+	}
+	assert.Equal(t, []string{"+", "+", "*", "-"}, ops)
+	first := tasks[0].ID
+
+	tasks, total, _ = tm.ListTasks(expr.ID, TaskFilter{Statuses: []string{"waiting"}})
+	assert.Equal(t, 2, total)
+	assert.Len(t, tasks, 2)
+
+	tasks, total, _ = tm.ListTasks(expr.ID, TaskFilter{Offset: 1, Limit: 2})
+	assert.Equal(t, 4, total)
+	assert.Equal(t, "+", tasks[0].Operation)
+	assert.Equal(t, "*", tasks[1].Operation)
+
+	tasks, _, _ = tm.ListTasks(expr.ID, TaskFilter{Offset: 10})
+	assert.Empty(t, tasks)
+
+	_, _, err = tm.ListTasks(expr.ID, TaskFilter{Statuses: []string{"done"}})
+	assert.ErrorIs(t, err, ErrUnknownStatus)
+	_, _, err = tm.ListTasks("missing", TaskFilter{})
+	assert.ErrorIs(t, err, ErrExpressionNotFound)
+
+	task, ok := tm.GetTask(first)
+	assert.True(t, ok)
+	assert.Equal(t, "pending", task.Status)
+	_, ok = tm.GetTask("missing")
+	assert.False(t, ok)
+
+	// У незавершённой задачи нет результата, а у завершённой он есть, даже нулевой
+	data, _ := json.Marshal(task)
+	assert.NotContains(t, string(data), `"result"`)
+	assert.NotContains(t, string(data), `"arg3"`)
+	expr, _ = tm.CreateExpression("2 - 2", Options{NoOptimize: true, NoCache: true})
+	runAgent(tm)
+	tasks, _, _ = tm.ListTasks(expr.ID, TaskFilter{})
+	data, _ = json.Marshal(tasks[0])
+	assert.Contains(t, string(data), `"result":0`)
+}
+
+func TestListExpressions(t *testing.T) {
+	tm := NewTaskManager()
+	opts := Options{NoOptimize: true, NoCache: true}
+	var ids []string
+	for i, input := range []string{"1 + 1", "2 + 2", "3 + 3", "4 + 4", "5"} {
+		opts.Submitter = []string{"alice", "bob"}[i%2]
+		expr, err := tm.CreateExpression(input, opts)
+		assert.NoError(t, err)
+		assert.False(t, expr.CreatedAt.IsZero())
+		ids = append(ids, expr.ID)
+	}
+	// "5" вычислено сразу, из остальных завершаем 3 + 3, затем 1 + 1
+	var taken []models.Task
+	for range 3 {
+		task, _ := tm.GetNextTask()
+		taken = append(taken, task)
+	}
+	tm.SaveTaskResult(taken[2].ID, models.Float(6))
+	tm.SaveTaskResult(taken[0].ID, models.Float(2))
+
+	idsOf := func(expressions []models.Expression) []string {
+		var result []string
+		for _, expr := range expressions {
+			result = append(result, expr.ID)
+		}
+		return result
+	}
+
+	page, next, err := tm.ListExpressions(ExpressionFilter{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, ids[:2], idsOf(page))
+	page, next, _ = tm.ListExpressions(ExpressionFilter{Limit: 2, Cursor: next})
+	assert.Equal(t, ids[2:4], idsOf(page))
+	page, next, _ = tm.ListExpressions(ExpressionFilter{Limit: 2, Cursor: next})
+	assert.Equal(t, ids[4:], idsOf(page))
+	assert.Empty(t, next)
+
+	page, _, _ = tm.ListExpressions(ExpressionFilter{Desc: true, Limit: 2})
+	assert.Equal(t, []string{ids[4], ids[3]}, idsOf(page))
+
+	page, _, _ = tm.ListExpressions(ExpressionFilter{Status: "processing", Submitter: "bob"})
+	assert.Equal(t, []string{ids[1], ids[3]}, idsOf(page))
+
+	page, _, _ = tm.ListExpressions(ExpressionFilter{SortBy: SortCompleted})
+	assert.Equal(t, []string{ids[4], ids[2], ids[0]}, idsOf(page))
+	for _, expr := range page {
+		assert.NotNil(t, expr.CompletedAt)
+		assert.Equal(t, "completed", expr.Status)
+	}
+
+	created, _ := tm.GetExpressionByID(ids[1])
+	page, _, _ = tm.ListExpressions(ExpressionFilter{CreatedAfter: created.CreatedAt})
+	assert.NotContains(t, idsOf(page), ids[0])
+	assert.NotContains(t, idsOf(page), ids[1])
+
+	_, _, err = tm.ListExpressions(ExpressionFilter{SortBy: "name"})
+	assert.ErrorIs(t, err, ErrUnknownSort)
+	_, _, err = tm.ListExpressions(ExpressionFilter{Status: "done"})
+	assert.ErrorIs(t, err, ErrUnknownStatus)
+	_, _, err = tm.ListExpressions(ExpressionFilter{Cursor: "!!"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, next, _ = tm.ListExpressions(ExpressionFilter{Limit: 1})
+	_, _, err = tm.ListExpressions(ExpressionFilter{SortBy: SortCompleted, Cursor: next})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestCreateExpressions(t *testing.T) {
+	tm := NewTaskManager()
+	results, err := tm.CreateExpressions([]BatchItem{
+		{Expression: "2 + 3"},
+		{Expression: "2 +"},
+		{Expression: "1/3 + 1/6", Options: Options{Numeric: models.NumericRational}},
+		{Expression: "1", Options: Options{Numeric: "octonion"}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, results, 4)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 5.0, results[0].Expression.Result)
+	assert.ErrorIs(t, results[1].Err, ErrInvalidExpression)
+	assert.NoError(t, results[2].Err)
+	assert.Equal(t, "1/2", results[2].Expression.Exact)
+	assert.ErrorIs(t, results[3].Err, ErrUnknownNumeric)
+
+	all := tm.GetAllExpressions()
+	assert.Len(t, all, 2)
+	assert.Equal(t, results[0].Expression.ID, all[0].ID)
+	assert.Equal(t, results[2].Expression.ID, all[1].ID)
+}
+
+func TestWaitExpression(t *testing.T) {
+	tm := NewTaskManager()
+	expr, _ := tm.CreateExpression("(2 + 3) * 4", Options{NoOptimize: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, finished, ok := tm.WaitExpression(ctx, expr.ID)
+	assert.True(t, ok)
+	assert.False(t, finished, "wait must give up on timeout")
+
+	go runAgent(tm)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	expr, finished, _ = tm.WaitExpression(ctx, expr.ID)
+	assert.True(t, finished)
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, 20.0, expr.Result)
+
+	// Завершённое выражение возвращается сразу
+	expr, finished, _ = tm.WaitExpression(context.Background(), expr.ID)
+	assert.True(t, finished)
+
+	_, _, ok = tm.WaitExpression(context.Background(), "missing")
+	assert.False(t, ok)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	tm := NewTaskManager()
+	opts := Options{IdempotencyKey: "k1", RequestHash: "h1", Submitter: "alice"}
+	first, err := tm.CreateExpression("2 + 3", opts)
+	assert.NoError(t, err)
+
+	replay, err := tm.CreateExpression("2 + 3", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, replay.ID)
+	assert.Len(t, tm.GetAllExpressions(), 1)
+
+	_, err = tm.CreateExpression("2 + 4", Options{IdempotencyKey: "k1", RequestHash: "h2", Submitter: "alice"})
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+
+	// Ключи разных отправителей не пересекаются
+	other, err := tm.CreateExpression("2 + 4", Options{IdempotencyKey: "k1", RequestHash: "h2", Submitter: "bob"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.ID, other.ID)
+
+	// Пакет использует те же ключи
+	results, err := tm.CreateExpressions([]BatchItem{{Expression: "2 + 3", Options: opts}})
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, first.ID, results[0].Expression.ID)
+}
+
+func TestIdempotencyStoreExpiry(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	now := time.Now()
+	store.remember("a", "h", "expr1", now)
+	store.remember("b", "h", "expr2", now.Add(30*time.Second))
+
+	id, ok, err := store.lookup("a", "h", now.Add(59*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "expr1", id)
+
+	_, ok, err = store.lookup("a", "other", now.Add(2*time.Minute))
+	assert.NoError(t, err, "expired key must not conflict")
+	assert.False(t, ok)
+
+	store.remember("c", "h", "expr3", now.Add(80*time.Second))
+	assert.NotContains(t, store.records, "a")
+	assert.Contains(t, store.records, "b")
+}
+
+func TestIdempotencyStoreKeyReuse(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	now := time.Now()
+	store.remember("a", "h", "expr1", now)
+	store.remember("b", "h", "expr2", now.Add(10*time.Second))
+	store.remember("a", "h2", "expr3", now.Add(30*time.Second))
+
+	// Прежняя запись "a" в начале очереди указывает на новую, ещё живую:
+	// она не должна задерживать вытеснение истёкшего "b"
+	store.remember("c", "h", "expr4", now.Add(75*time.Second))
+	assert.NotContains(t, store.records, "b")
+	assert.Len(t, store.records, 2)
+	assert.Len(t, store.order, 2)
+
+	id, ok, err := store.lookup("a", "h2", now.Add(75*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "expr3", id)
+
+	store.remember("d", "h", "expr5", now.Add(5*time.Minute))
+	assert.Len(t, store.records, 1)
+	assert.Len(t, store.order, 1)
+}
+
+func TestListExpressionsByOwner(t *testing.T) {
+	tm := NewTaskManager()
+	mine, _ := tm.CreateExpression("2 + 3", Options{Owner: "alice"})
+	tm.CreateExpression("2 + 4", Options{Owner: "bob"})
+	anonymous, _ := tm.CreateExpression("2 + 5", Options{})
+
+	owned, _, err := tm.ListExpressions(ExpressionFilter{Owner: "alice", ByOwner: true})
+	assert.NoError(t, err)
+	assert.Len(t, owned, 1)
+	assert.Equal(t, mine.ID, owned[0].ID)
+	assert.Equal(t, "alice", owned[0].Owner)
+
+	owned, _, _ = tm.ListExpressions(ExpressionFilter{ByOwner: true})
+	assert.Len(t, owned, 1)
+	assert.Equal(t, anonymous.ID, owned[0].ID)
+
+	all, _, _ := tm.ListExpressions(ExpressionFilter{})
+	assert.Len(t, all, 3)
+}
@@ -0,0 +1,161 @@
+package task_manager
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+// tracer берётся заново для каждого спана: провайдер трассировки может
+// смениться после создания TaskManager (tracing.InMemory в тестах)
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/m1tka051209/arithmetic-service/orchestrator/task_manager")
+}
+
+// taskTraces — незавершённые спаны выражений и задач. Спан выражения —
+// потомок запроса, создавшего выражение, спаны задач — потомки спана
+// выражения. Хранятся только записываемые спаны: при выключенной
+// трассировке карты остаются пустыми. Доступ — под tm.mu.
+type taskTraces struct {
+	expressions map[string]trace.Span
+	tasks       map[string]trace.Span
+	queued      map[string]time.Time // ID задачи -> когда она попала в очередь агентов
+}
+
+func newTaskTraces() *taskTraces {
+	return &taskTraces{
+		expressions: make(map[string]trace.Span),
+		tasks:       make(map[string]trace.Span),
+		queued:      make(map[string]time.Time),
+	}
+}
+
+// noSpan — незаписываемый спан для задач и выражений без трассировки
+var noSpan = trace.SpanFromContext(context.Background())
+
+func (t *taskTraces) task(id string) trace.Span {
+	if span, ok := t.tasks[id]; ok {
+		return span
+	}
+	return noSpan
+}
+
+// endSpan завершает спан, отмечая ошибку, если она есть
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startExpressionSpan открывает спан выражения, который закроет finishExpression.
+// Вызывается под tm.mu.
+func (tm *TaskManager) startExpressionSpan(p preparedExpression, expr models.Expression) {
+	ctx := trace.ContextWithSpanContext(context.Background(), p.parent)
+	_, span := tracer().Start(ctx, "expression", trace.WithTimestamp(expr.CreatedAt), trace.WithAttributes(
+		attribute.String("expression.id", expr.ID),
+		attribute.String("expression.numeric", expr.Numeric),
+		attribute.Int("expression.tasks", len(p.graph.tasks)),
+	))
+	if span.IsRecording() {
+		tm.traces.expressions[expr.ID] = span
+	}
+}
+
+// startTaskSpans открывает спаны задач выражения. Вызывается под tm.mu
+// до постановки задач в очередь: задача из кэша завершается сразу.
+func (tm *TaskManager) startTaskSpans(tasks []models.Task) {
+	for _, task := range tasks {
+		parent, ok := tm.traces.expressions[task.ExpressionID]
+		if !ok {
+			continue
+		}
+		_, span := tracer().Start(trace.ContextWithSpan(context.Background(), parent), "task", trace.WithAttributes(
+			attribute.String("task.id", task.ID),
+			attribute.String("task.operation", task.Operation),
+			attribute.String("expression.id", task.ExpressionID),
+		))
+		tm.traces.tasks[task.ID] = span
+	}
+}
+
+// pushQueue ставит задачу в очередь агентов и запоминает, когда она туда
+// попала. Вызывается под tm.mu.
+func (tm *TaskManager) pushQueue(id string) {
+	tm.queue = append(tm.queue, id)
+	if _, ok := tm.traces.tasks[id]; ok {
+		tm.traces.queued[id] = time.Now()
+	}
+}
+
+// traceDispatch записывает ожидание задачи в очереди до выдачи агенту.
+// Вызывается под tm.mu.
+func (tm *TaskManager) traceDispatch(task models.Task, now time.Time) {
+	span := tm.traces.task(task.ID)
+	queued, ok := tm.traces.queued[task.ID]
+	delete(tm.traces.queued, task.ID)
+	if !ok {
+		return
+	}
+	_, wait := tracer().Start(trace.ContextWithSpan(context.Background(), span), "queue wait",
+		trace.WithTimestamp(queued), trace.WithAttributes(attribute.String("agent.id", task.AgentID)))
+	wait.End(trace.WithTimestamp(now))
+}
+
+// endTaskSpan закрывает спан завершённой задачи. Вызывается под tm.mu.
+func (tm *TaskManager) endTaskSpan(task models.Task) {
+	span, ok := tm.traces.tasks[task.ID]
+	if !ok {
+		return
+	}
+	delete(tm.traces.tasks, task.ID)
+	delete(tm.traces.queued, task.ID)
+	span.SetAttributes(attribute.String("task.status", task.Status))
+	if task.AgentID != "" {
+		span.SetAttributes(attribute.String("agent.id", task.AgentID))
+	}
+	if len(task.VerifiedBy) > 0 {
+		span.SetAttributes(attribute.StringSlice("task.verified_by", task.VerifiedBy))
+	}
+	if task.Error != "" {
+		span.SetStatus(codes.Error, errorReasons[task.Error])
+		span.SetAttributes(attribute.String("task.error_code", task.Error))
+	}
+	span.End()
+}
+
+// endExpressionSpan закрывает спан выражения и спаны его задач, которые
+// так и не выполнились (отменённые, пропущенные ветви). Вызывается под tm.mu.
+func (tm *TaskManager) endExpressionSpan(expr models.Expression) {
+	span, ok := tm.traces.expressions[expr.ID]
+	if !ok {
+		return
+	}
+	delete(tm.traces.expressions, expr.ID)
+	for _, id := range tm.exprTasks[expr.ID] {
+		tm.endTaskSpan(tm.tasks[id])
+	}
+	span.SetAttributes(attribute.String("expression.status", expr.Status))
+	if expr.Status == "error" {
+		span.SetStatus(codes.Error, expr.Error)
+	}
+	span.End()
+}
+
+// TaskContext возвращает ctx со спаном задачи: агент продолжает трассу
+// выражения, получив этот контекст вместе с задачей
+func (tm *TaskManager) TaskContext(ctx context.Context, taskID string) context.Context {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if span, ok := tm.traces.tasks[taskID]; ok {
+		return trace.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
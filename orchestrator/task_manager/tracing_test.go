@@ -0,0 +1,133 @@
+package task_manager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+	"github.com/m1tka051209/arithmetic-service/tracing"
+)
+
+// spansNamed отбирает завершённые спаны с заданным именем
+func spansNamed(spans tracetest.SpanStubs, name string) tracetest.SpanStubs {
+	var named tracetest.SpanStubs
+	for _, s := range spans {
+		if s.Name == name {
+			named = append(named, s)
+		}
+	}
+	return named
+}
+
+func attr(s tracetest.SpanStub, key string) string {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestExpressionTrace(t *testing.T) {
+	exporter := tracing.InMemory()
+	tm := NewTaskManager()
+
+	ctx, request := tracer().Start(context.Background(), "request")
+	expr, err := tm.CreateExpressionContext(ctx, "(1 + 2) * 3", Options{NoOptimize: true, NoCache: true})
+	require.NoError(t, err)
+	request.End()
+
+	for {
+		task, ok := tm.GetNextTaskFor("agent-1")
+		if !ok {
+			break
+		}
+		result, _ := calculateTask(task)
+		_, err := tm.SaveTaskResultFrom(task.ID, "agent-1", result)
+		require.NoError(t, err)
+	}
+
+	spans := exporter.GetSpans()
+	traceID := request.SpanContext().TraceID()
+	for _, s := range spans {
+		assert.Equal(t, traceID, s.SpanContext.TraceID(), "%s is not in the expression trace", s.Name)
+	}
+
+	parse := spansNamed(spans, "parse expression")
+	require.Len(t, parse, 1)
+	assert.Equal(t, request.SpanContext().SpanID(), parse[0].Parent.SpanID())
+
+	exprSpans := spansNamed(spans, "expression")
+	require.Len(t, exprSpans, 1)
+	assert.Equal(t, request.SpanContext().SpanID(), exprSpans[0].Parent.SpanID())
+	assert.Equal(t, expr.ID, attr(exprSpans[0], "expression.id"))
+	assert.Equal(t, "completed", attr(exprSpans[0], "expression.status"))
+
+	tasks := spansNamed(spans, "task")
+	require.Len(t, tasks, 2)
+	taskSpans := make(map[string]tracetest.SpanStub)
+	for _, s := range tasks {
+		assert.Equal(t, exprSpans[0].SpanContext.SpanID(), s.Parent.SpanID())
+		assert.Equal(t, "completed", attr(s, "task.status"))
+		assert.Equal(t, "agent-1", attr(s, "agent.id"))
+		taskSpans[s.SpanContext.SpanID().String()] = s
+	}
+
+	// Ожидание в очереди — у каждой задачи, внутри её спана
+	waits := spansNamed(spans, "queue wait")
+	require.Len(t, waits, 2)
+	for _, wait := range waits {
+		task, ok := taskSpans[wait.Parent.SpanID().String()]
+		require.True(t, ok)
+		assert.False(t, wait.StartTime.Before(task.StartTime))
+		assert.False(t, wait.EndTime.After(task.EndTime))
+	}
+}
+
+func TestExpressionTraceError(t *testing.T) {
+	exporter := tracing.InMemory()
+	tm := NewTaskManager()
+
+	_, err := tm.CreateExpressionContext(context.Background(), "1 / (2 - 2) + 2 * 3", Options{NoOptimize: true, NoCache: true})
+	require.NoError(t, err)
+	sub, ok := tm.GetNextTaskFor("agent-1")
+	require.True(t, ok)
+	require.Equal(t, "-", sub.Operation)
+	_, err = tm.SaveTaskResultFrom(sub.ID, "agent-1", models.Float(0))
+	require.NoError(t, err)
+	mul, ok := tm.GetNextTaskFor("agent-2")
+	require.True(t, ok)
+	require.Equal(t, "*", mul.Operation)
+	div, ok := tm.GetNextTaskFor("agent-1")
+	require.True(t, ok)
+	require.Equal(t, "/", div.Operation)
+	_, err = tm.SaveTaskErrorFrom(div.ID, "agent-1", models.ErrorDivisionByZero)
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	exprSpans := spansNamed(spans, "expression")
+	require.Len(t, exprSpans, 1)
+	assert.Equal(t, codes.Error, exprSpans[0].Status.Code)
+	assert.Equal(t, "division by zero", exprSpans[0].Status.Description)
+
+	// Незавершённые задачи закрываются вместе с выражением
+	statuses := make(map[string]string)
+	for _, s := range spansNamed(spans, "task") {
+		statuses[attr(s, "task.operation")] = attr(s, "task.status")
+	}
+	assert.Equal(t, map[string]string{"-": "completed", "/": "error", "*": "in_progress", "+": "cancelled"}, statuses)
+	assert.Empty(t, tm.traces.tasks)
+	assert.Empty(t, tm.traces.expressions)
+
+	// Неудачный разбор отмечается в спане разбора
+	_, err = tm.CreateExpressionContext(context.Background(), "1 +", Options{})
+	require.Error(t, err)
+	parse := spansNamed(exporter.GetSpans(), "parse expression")
+	require.Len(t, parse, 2)
+	assert.Equal(t, codes.Error, parse[1].Status.Code)
+}
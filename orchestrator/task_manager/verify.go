@@ -0,0 +1,368 @@
+package task_manager
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+var (
+	ErrAgentQuarantined = errors.New("agent is quarantined")
+	ErrTaskNotAssigned  = errors.New("task is not assigned to this agent")
+)
+
+// verifier — проверка результатов повторным выполнением. Выбранная задача
+// выдаётся replicas разным агентам; результат принимается, когда его прислало
+// строгое большинство агентов. Агенты, приславшие другой результат, попадают
+// в карантин и больше не получают задач. Если большинства нет, задача
+// выдаётся ещё replicas новым агентам, но не больше maxRounds раз. Раунд,
+// не собравший ответы за roundTimeout (например, не хватило агентов, ещё не
+// выполнявших задачу), завершает задачу ошибкой unverified.
+type verifier struct {
+	sampleRate   float64 // доля проверяемых задач: 0 — ни одной, 1 — все
+	replicas     int     // сколько разных агентов выполняют задачу за раунд
+	tolerance    float64 // допустимое относительное расхождение результатов с плавающей точкой
+	maxRounds    int
+	roundTimeout time.Duration
+	rand         *rand.Rand
+
+	rounds  map[string]*verification // ID задачи -> её проверка
+	pending []string                 // задачи, которым не хватает исполнителей
+	agents  map[string]*AgentStats
+}
+
+// verification — ход проверки одной задачи
+type verification struct {
+	round     int
+	deadline  time.Time       // когда раунд без всех ответов завершит задачу ошибкой
+	assigned  map[string]bool // агенты, которые взяли задачу и ещё не ответили
+	withdrawn map[string]bool // агенты, снятые с задачи карантином до ответа
+	results   []submission
+}
+
+type submission struct {
+	agentID string
+	value   models.Value
+	code    string // код ошибки вместо результата
+}
+
+// AgentStats — итоги проверки результатов агента
+type AgentStats struct {
+	AgentID       string     `json:"agent_id"`
+	Verified      int        `json:"verified"`      // результаты, участвовавшие в сравнении
+	Disagreements int        `json:"disagreements"` // из них не совпавшие с принятым
+	Quarantined   bool       `json:"quarantined"`
+	QuarantinedAt *time.Time `json:"quarantined_at,omitempty"`
+}
+
+// newVerifier читает VERIFY_SAMPLE_RATE, VERIFY_REPLICAS, VERIFY_TOLERANCE,
+// VERIFY_MAX_ROUNDS и VERIFY_ROUND_TIMEOUT. Без VERIFY_SAMPLE_RATE проверка
+// выключена (nil).
+func newVerifier() *verifier {
+	rate, err := strconv.ParseFloat(os.Getenv("VERIFY_SAMPLE_RATE"), 64)
+	if err != nil || rate <= 0 {
+		return nil
+	}
+	tolerance, err := strconv.ParseFloat(os.Getenv("VERIFY_TOLERANCE"), 64)
+	if err != nil || tolerance < 0 {
+		tolerance = 1e-9
+	}
+	return &verifier{
+		sampleRate:   min(rate, 1),
+		replicas:     max(getIntFromEnv("VERIFY_REPLICAS", 2), 2),
+		tolerance:    tolerance,
+		maxRounds:    max(getIntFromEnv("VERIFY_MAX_ROUNDS", 3), 1),
+		roundTimeout: getIntervalFromEnv("VERIFY_ROUND_TIMEOUT", time.Minute),
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		rounds:       make(map[string]*verification),
+		agents:       make(map[string]*AgentStats),
+	}
+}
+
+func (v *verifier) sampled() bool {
+	return v.sampleRate >= 1 || v.rand.Float64() < v.sampleRate
+}
+
+func (v *verifier) quarantined(agentID string) bool {
+	stats, ok := v.agents[agentID]
+	return ok && stats.Quarantined
+}
+
+func (v *verifier) stats(agentID string) *AgentStats {
+	stats, ok := v.agents[agentID]
+	if !ok {
+		stats = &AgentStats{AgentID: agentID}
+		v.agents[agentID] = stats
+	}
+	return stats
+}
+
+// participated сообщает, выполнял ли агент задачу в каком-либо раунде
+func (p *verification) participated(agentID string) bool {
+	if p.assigned[agentID] || p.withdrawn[agentID] {
+		return true
+	}
+	for _, s := range p.results {
+		if s.agentID == agentID {
+			return true
+		}
+	}
+	return false
+}
+
+// wanted — сколько исполнителей ещё нужно текущему раунду
+func (p *verification) wanted(replicas int) int {
+	return p.round*replicas - len(p.results) - len(p.assigned)
+}
+
+// startVerification решает, проверять ли только что выданную задачу,
+// и если да — записывает первого исполнителя. Агенты без ID (без ключей
+// и mTLS, без X-Agent-ID) неотличимы друг от друга: второй исполнитель
+// для их задачи не нашёлся бы никогда, поэтому такие задачи не проверяются.
+// Вызывается под tm.mu.
+func (tm *TaskManager) startVerification(task models.Task) {
+	v := tm.verify
+	if v == nil || task.AgentID == "" || !v.sampled() {
+		return
+	}
+	v.rounds[task.ID] = &verification{
+		round:     1,
+		deadline:  time.Now().Add(v.roundTimeout),
+		assigned:  map[string]bool{task.AgentID: true},
+		withdrawn: make(map[string]bool),
+	}
+	v.pending = append(v.pending, task.ID)
+}
+
+// nextReplica выдаёт агенту проверяемую задачу, которую он ещё не выполнял.
+// Агенту без ID повторы не выдаются. Вызывается под tm.mu.
+func (tm *TaskManager) nextReplica(agentID string) (models.Task, bool) {
+	v := tm.verify
+	if agentID == "" {
+		return models.Task{}, false
+	}
+	for i := 0; i < len(v.pending); i++ {
+		id := v.pending[i]
+		p, ok := v.rounds[id]
+		task := tm.tasks[id]
+		if !ok || task.Status != "in_progress" || p.wanted(v.replicas) <= 0 {
+			v.pending = append(v.pending[:i], v.pending[i+1:]...)
+			i--
+			continue
+		}
+		if p.participated(agentID) {
+			continue
+		}
+		p.assigned[agentID] = true
+		if p.wanted(v.replicas) == 0 {
+			v.pending = append(v.pending[:i], v.pending[i+1:]...)
+		}
+		task.AgentID = agentID
+		return task, true
+	}
+	return models.Task{}, false
+}
+
+// settle принимает ответ агента (результат или код ошибки) по выданной задаче.
+// Непроверяемая задача завершается сразу, проверяемая — когда ответы
+// исполнителей сойдутся. Вызывается под tm.mu.
+func (tm *TaskManager) settle(task models.Task, s submission) error {
+	if tm.verify == nil {
+		tm.finishSubmission(task, s)
+		return nil
+	}
+	v := tm.verify
+	if v.quarantined(s.agentID) {
+		return ErrAgentQuarantined
+	}
+	p, ok := v.rounds[task.ID]
+	if !ok {
+		tm.finishSubmission(task, s)
+		return nil
+	}
+	if !p.assigned[s.agentID] {
+		if p.participated(s.agentID) {
+			return ErrTaskCompleted
+		}
+		return ErrTaskNotAssigned
+	}
+	delete(p.assigned, s.agentID)
+	p.results = append(p.results, s)
+	if len(p.assigned) > 0 || p.wanted(v.replicas) > 0 {
+		return nil
+	}
+
+	accepted, ok := v.majority(p.results)
+	switch {
+	case ok:
+		delete(v.rounds, task.ID)
+		now := time.Now()
+		for _, r := range p.results {
+			stats := v.stats(r.agentID)
+			stats.Verified++
+			if v.agree(r, accepted) {
+				task.VerifiedBy = append(task.VerifiedBy, r.agentID)
+				continue
+			}
+			stats.Disagreements++
+			if !stats.Quarantined {
+				stats.Quarantined, stats.QuarantinedAt = true, &now
+				tm.releaseAssignments(r.agentID)
+				slog.Warn("agent quarantined", "agent_id", r.agentID, "task_id", task.ID, "expression_id", task.ExpressionID)
+			}
+		}
+		tm.finishSubmission(task, accepted)
+	case p.round < v.maxRounds:
+		p.round++
+		p.deadline = time.Now().Add(v.roundTimeout)
+		v.pending = append(v.pending, task.ID)
+	default:
+		delete(v.rounds, task.ID)
+		for _, r := range p.results {
+			v.stats(r.agentID).Verified++
+		}
+		slog.Warn("agents disagreed on the result", "task_id", task.ID, "expression_id", task.ExpressionID, "rounds", p.round)
+		tm.failTask(task, models.ErrorUnverified)
+	}
+	return nil
+}
+
+func (tm *TaskManager) finishSubmission(task models.Task, s submission) {
+	tm.metrics.observe(task, s.code)
+	if s.code != "" {
+		tm.failTask(task, s.code)
+	} else {
+		tm.completeTask(task, s.value)
+	}
+}
+
+// expireRounds завершает ошибкой unverified проверки, раунд которых не собрал
+// ответы к сроку. Вызывается под tm.mu.
+func (tm *TaskManager) expireRounds(now time.Time) {
+	v := tm.verify
+	for id, p := range v.rounds {
+		if now.Before(p.deadline) {
+			continue
+		}
+		delete(v.rounds, id)
+		task := tm.tasks[id]
+		if task.Status != "in_progress" {
+			continue
+		}
+		for _, r := range p.results {
+			v.stats(r.agentID).Verified++
+		}
+		slog.Warn("verification round timed out", "task_id", id, "expression_id", task.ExpressionID,
+			"round", p.round, "results", len(p.results))
+		tm.failTask(task, models.ErrorUnverified)
+	}
+}
+
+// releaseAssignments снимает с агента невыполненные задачи, чтобы их взяли
+// другие агенты: у проверяемых освобождается место исполнителя, остальные
+// возвращаются в очередь. Ответы агента в карантине не принимаются, поэтому
+// без этого его задачи и их выражения не завершились бы никогда.
+// Вызывается под tm.mu.
+func (tm *TaskManager) releaseAssignments(agentID string) {
+	v := tm.verify
+	for id, p := range v.rounds {
+		if p.assigned[agentID] {
+			delete(p.assigned, agentID)
+			p.withdrawn[agentID] = true
+			v.pending = append(v.pending, id)
+		}
+	}
+	for id, task := range tm.tasks {
+		if _, verified := v.rounds[id]; verified || task.Status != "in_progress" || task.AgentID != agentID {
+			continue
+		}
+		tm.requeue(task)
+	}
+}
+
+// majority возвращает ответ, совпадающий с ответами строгого большинства
+// (и не меньше двух) исполнителей
+func (v *verifier) majority(results []submission) (submission, bool) {
+	for _, candidate := range results {
+		votes := 0
+		for _, r := range results {
+			if v.agree(candidate, r) {
+				votes++
+			}
+		}
+		if votes >= 2 && votes*2 > len(results) {
+			return candidate, true
+		}
+	}
+	return submission{}, false
+}
+
+// agree сравнивает ответы: коды ошибок — на равенство, дроби и логические
+// значения — точно, числа с плавающей точкой — с относительной погрешностью
+func (v *verifier) agree(a, b submission) bool {
+	if a.code != "" || b.code != "" {
+		return a.code == b.code
+	}
+	x, y := a.value, b.value
+	if x.Numeric() != y.Numeric() {
+		return false
+	}
+	switch x.Numeric() {
+	case models.KindBool:
+		return x.Bool == y.Bool
+	case models.NumericRational:
+		return x.Rat.Cmp(y.Rat) == 0
+	case models.NumericComplex:
+		return v.close(real(x.Complex), real(y.Complex)) && v.close(imag(x.Complex), imag(y.Complex))
+	default:
+		return v.close(x.Float, y.Float)
+	}
+}
+
+func (v *verifier) close(a, b float64) bool {
+	if a == b || math.IsNaN(a) && math.IsNaN(b) {
+		return true
+	}
+	return math.Abs(a-b) <= v.tolerance*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// AgentStats возвращает итоги проверки результатов по агентам
+func (tm *TaskManager) AgentStats() []AgentStats {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.verify == nil {
+		return []AgentStats{}
+	}
+	stats := make([]AgentStats, 0, len(tm.verify.agents))
+	for _, s := range tm.verify.agents {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].AgentID < stats[j].AgentID })
+	return stats
+}
+
+// IsQuarantined сообщает, находится ли агент в карантине
+func (tm *TaskManager) IsQuarantined(agentID string) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.verify != nil && tm.verify.quarantined(agentID)
+}
+
+// ReleaseAgent выводит агента из карантина. false, если он не был в карантине.
+func (tm *TaskManager) ReleaseAgent(agentID string) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.verify == nil || !tm.verify.quarantined(agentID) {
+		return false
+	}
+	stats := tm.verify.agents[agentID]
+	stats.Quarantined, stats.QuarantinedAt = false, nil
+	return true
+}
@@ -0,0 +1,297 @@
+package task_manager
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newVerifyingTaskManager проверяет каждую задачу на replicas агентах за maxRounds раундов
+func newVerifyingTaskManager(replicas, maxRounds int) *TaskManager {
+	tm := NewTaskManager()
+	tm.verify = &verifier{
+		sampleRate:   1,
+		replicas:     replicas,
+		tolerance:    1e-9,
+		maxRounds:    maxRounds,
+		roundTimeout: time.Minute,
+		rand:         rand.New(rand.NewSource(1)),
+		rounds:       make(map[string]*verification),
+		agents:       make(map[string]*AgentStats),
+	}
+	return tm
+}
+
+// dispatch выдаёт задачу каждому агенту и проверяет, что это одна и та же задача
+func dispatch(t *testing.T, tm *TaskManager, agents ...string) string {
+	t.Helper()
+	var id string
+	for _, agent := range agents {
+		task, ok := tm.GetNextTaskFor(agent)
+		require.True(t, ok, agent)
+		if id == "" {
+			id = task.ID
+		}
+		require.Equal(t, id, task.ID, agent)
+	}
+	return id
+}
+
+func statsOf(tm *TaskManager) map[string]AgentStats {
+	stats := map[string]AgentStats{}
+	for _, s := range tm.AgentStats() {
+		stats[s.AgentID] = s
+	}
+	return stats
+}
+
+func TestVerificationAgreement(t *testing.T) {
+	tm := newVerifyingTaskManager(2, 3)
+	expr, _ := tm.CreateExpression("2 + 3", Options{NoOptimize: true})
+
+	id := dispatch(t, tm, "a1")
+	_, ok := tm.GetNextTaskFor("a1")
+	assert.False(t, ok, "the same agent must not verify its own result")
+	dispatch(t, tm, "a2")
+	_, ok = tm.GetNextTaskFor("a3")
+	assert.False(t, ok, "the task already has enough replicas")
+
+	_, err := tm.SaveTaskResultFrom(id, "a3", models.Float(5))
+	assert.ErrorIs(t, err, ErrTaskNotAssigned)
+	_, err = tm.SaveTaskResultFrom(id, "a1", models.Float(5))
+	require.NoError(t, err)
+	_, err = tm.SaveTaskResultFrom(id, "a1", models.Float(5))
+	assert.ErrorIs(t, err, ErrTaskCompleted)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "processing", expr.Status, "one result is not enough")
+
+	_, err = tm.SaveTaskResultFrom(id, "a2", models.Float(5+1e-12))
+	require.NoError(t, err)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, 5.0, expr.Result)
+	task, _ := tm.GetTask(id)
+	assert.Equal(t, []string{"a1", "a2"}, task.VerifiedBy)
+
+	stats := statsOf(tm)
+	assert.Equal(t, AgentStats{AgentID: "a1", Verified: 1}, stats["a1"])
+	assert.Equal(t, AgentStats{AgentID: "a2", Verified: 1}, stats["a2"])
+}
+
+func TestVerificationQuarantine(t *testing.T) {
+	tm := newVerifyingTaskManager(3, 3)
+	expr, _ := tm.CreateExpression("(2 + 3) * 2", Options{NoOptimize: true})
+
+	id := dispatch(t, tm, "a1", "a2", "a3")
+	tm.SaveTaskResultFrom(id, "a1", models.Float(5))
+	tm.SaveTaskResultFrom(id, "a2", models.Float(6))
+	task, _ := tm.GetTask(id)
+	assert.Equal(t, "in_progress", task.Status, "a3 has not answered yet")
+	tm.SaveTaskResultFrom(id, "a3", models.Float(5))
+
+	// 5, 6, 5 — большинство за 5, a2 в карантине
+	task, _ = tm.GetTask(id)
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, []string{"a1", "a3"}, task.VerifiedBy)
+	assert.True(t, tm.IsQuarantined("a2"))
+	assert.False(t, tm.IsQuarantined("a1"))
+	_, ok := tm.GetNextTaskFor("a2")
+	assert.False(t, ok, "a quarantined agent gets no tasks")
+	assert.Equal(t, 1, statsOf(tm)["a2"].Disagreements)
+	assert.NotNil(t, statsOf(tm)["a2"].QuarantinedAt)
+
+	// Зависимая задача получила принятый результат
+	next := dispatch(t, tm, "a1")
+	dependent, _ := tm.GetTask(next)
+	assert.Equal(t, 5.0, dependent.Arg1.Float64())
+	_, err := tm.SaveTaskResultFrom(next, "a2", models.Float(10))
+	assert.ErrorIs(t, err, ErrAgentQuarantined)
+
+	assert.True(t, tm.ReleaseAgent("a2"))
+	assert.False(t, tm.ReleaseAgent("a2"))
+	assert.False(t, tm.IsQuarantined("a2"))
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "processing", expr.Status)
+}
+
+func TestQuarantineRequeuesTasks(t *testing.T) {
+	tm := newVerifyingTaskManager(3, 3)
+	expr, _ := tm.CreateExpression("(2 + 3) * (4 + 5)", Options{NoOptimize: true})
+
+	checked := dispatch(t, tm, "a1", "a2", "a3")
+	// Вторую задачу a2 берёт без проверки, а затем попадает в карантин
+	tm.verify.sampleRate = 0
+	held := dispatch(t, tm, "a2")
+	require.NotEqual(t, checked, held)
+	tm.SaveTaskResultFrom(checked, "a1", models.Float(5))
+	tm.SaveTaskResultFrom(checked, "a2", models.Float(6))
+	tm.SaveTaskResultFrom(checked, "a3", models.Float(5))
+	require.True(t, tm.IsQuarantined("a2"))
+
+	// Задача уже вернулась в очередь, поэтому опоздавший ответ не принимается
+	_, err := tm.SaveTaskResultFrom(held, "a2", models.Float(9))
+	assert.ErrorIs(t, err, ErrTaskNotInProgress)
+	task, _ := tm.GetTask(held)
+	assert.Equal(t, "pending", task.Status, "the task of a quarantined agent returns to the queue")
+	assert.Empty(t, task.AgentID)
+
+	assert.Equal(t, held, dispatch(t, tm, "a1"))
+	_, err = tm.SaveTaskResultFrom(held, "a1", models.Float(9))
+	require.NoError(t, err)
+	root := dispatch(t, tm, "a3")
+	_, err = tm.SaveTaskResultFrom(root, "a3", models.Float(45))
+	require.NoError(t, err)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, 45.0, expr.Result)
+}
+
+func TestVerificationReexecution(t *testing.T) {
+	tm := newVerifyingTaskManager(2, 3)
+	expr, _ := tm.CreateExpression("2 + 3", Options{NoOptimize: true})
+
+	id := dispatch(t, tm, "a1", "a2")
+	tm.SaveTaskResultFrom(id, "a1", models.Float(5))
+	tm.SaveTaskResultFrom(id, "a2", models.Float(6))
+
+	// Большинства нет — задача выдаётся двум новым агентам
+	_, ok := tm.GetNextTaskFor("a1")
+	assert.False(t, ok)
+	dispatch(t, tm, "a3", "a4")
+	// Один из новых агентов попадает в карантин раньше, чем ответит: его место занимает другой
+	tm.verify.stats("a4").Quarantined = true
+	tm.releaseAssignments("a4")
+	_, err := tm.SaveTaskResultFrom(id, "a4", models.Float(6))
+	assert.ErrorIs(t, err, ErrAgentQuarantined)
+	dispatch(t, tm, "a5")
+
+	tm.SaveTaskResultFrom(id, "a3", models.Float(5))
+	tm.SaveTaskResultFrom(id, "a5", models.Float(5))
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, 5.0, expr.Result)
+	assert.True(t, tm.IsQuarantined("a2"))
+	assert.False(t, tm.IsQuarantined("a1"))
+}
+
+func TestVerificationRoundTimeout(t *testing.T) {
+	tm := newVerifyingTaskManager(2, 3)
+	expr, _ := tm.CreateExpression("2 + 3", Options{NoOptimize: true})
+
+	id := dispatch(t, tm, "a1", "a2")
+	tm.SaveTaskResultFrom(id, "a1", models.Float(5))
+	tm.SaveTaskResultFrom(id, "a2", models.Float(6))
+
+	// Второму раунду нужны два новых агента, а есть только a3
+	dispatch(t, tm, "a3")
+	for _, agent := range []string{"a1", "a2", "a3"} {
+		_, ok := tm.GetNextTaskFor(agent)
+		assert.False(t, ok, agent)
+	}
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "processing", expr.Status)
+
+	tm.mu.Lock()
+	tm.verify.rounds[id].deadline = time.Now().Add(-time.Second)
+	tm.mu.Unlock()
+	_, ok := tm.GetNextTaskFor("a1")
+	assert.False(t, ok)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, "agents disagreed on the result", expr.Error)
+	_, err := tm.SaveTaskResultFrom(id, "a3", models.Float(5))
+	assert.ErrorIs(t, err, ErrTaskCompleted)
+	assert.Equal(t, 1, statsOf(tm)["a1"].Verified)
+	assert.False(t, tm.IsQuarantined("a2"), "nobody outvoted a2")
+}
+
+func TestVerificationWithoutConsensus(t *testing.T) {
+	tm := newVerifyingTaskManager(2, 1)
+	expr, _ := tm.CreateExpression("2 + 3", Options{NoOptimize: true})
+
+	id := dispatch(t, tm, "a1", "a2")
+	tm.SaveTaskResultFrom(id, "a1", models.Float(5))
+	tm.SaveTaskErrorFrom(id, "a2", models.ErrorOverflow)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, "agents disagreed on the result", expr.Error)
+	assert.False(t, tm.IsQuarantined("a1"))
+	assert.False(t, tm.IsQuarantined("a2"))
+
+	_, err := tm.SaveTaskError(id, models.ErrorUnverified)
+	assert.ErrorIs(t, err, ErrUnknownErrorCode, "agents cannot report verification failures")
+}
+
+func TestVerificationAgreesOnErrors(t *testing.T) {
+	tm := newVerifyingTaskManager(2, 3)
+	expr, _ := tm.CreateExpression("1 / (2 - 2)", Options{NoOptimize: true})
+
+	id := dispatch(t, tm, "a1", "a2")
+	tm.SaveTaskResultFrom(id, "a1", models.Float(0))
+	tm.SaveTaskResultFrom(id, "a2", models.Float(0))
+	id = dispatch(t, tm, "a1", "a2")
+	tm.SaveTaskErrorFrom(id, "a1", models.ErrorDivisionByZero)
+	tm.SaveTaskErrorFrom(id, "a2", models.ErrorDivisionByZero)
+
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, "division by zero", expr.Error)
+}
+
+func TestVerifierAgree(t *testing.T) {
+	v := &verifier{tolerance: 1e-9}
+	value := func(v models.Value) submission { return submission{value: v} }
+
+	assert.True(t, v.agree(value(models.Float(1e20)), value(models.Float(1e20+1e10))))
+	assert.False(t, v.agree(value(models.Float(1)), value(models.Float(1.001))))
+	assert.False(t, v.agree(value(models.Float(1)), value(models.Bool(true))))
+	assert.True(t, v.agree(value(models.Rational(big.NewRat(1, 2))), value(models.Rational(big.NewRat(2, 4)))))
+	assert.False(t, v.agree(value(models.Rational(big.NewRat(1, 2))), value(models.Rational(big.NewRat(500000001, 1000000000)))))
+	assert.True(t, v.agree(value(models.Complex(1+2i)), value(models.Complex(1+(2+1e-12)*1i))))
+	assert.False(t, v.agree(value(models.Complex(1+2i)), value(models.Complex(1-2i))))
+	assert.False(t, v.agree(submission{code: models.ErrorOverflow}, value(models.Float(1))))
+	assert.True(t, v.agree(submission{code: models.ErrorOverflow}, submission{code: models.ErrorOverflow}))
+}
+
+func TestQuarantinedAgentIsNotCountedForAdmission(t *testing.T) {
+	tm := newVerifyingTaskManager(2, 3)
+	tm.admission.tasksPerAgent = 1
+	tm.verify.stats("bad").Quarantined = true
+
+	tm.GetNextTaskFor("good")
+	_, ok := tm.GetNextTaskFor("bad")
+	assert.False(t, ok)
+	assert.Equal(t, AdmissionStats{Capacity: 1, ActiveAgents: 1}, tm.Admission(), "polling in quarantine adds no capacity")
+}
+
+func TestVerificationSkipsAnonymousAgents(t *testing.T) {
+	tm := newVerifyingTaskManager(2, 3)
+	expr, _ := tm.CreateExpression("2 + 3", Options{NoOptimize: true})
+
+	// Без ключей и X-Agent-ID все агенты приходят с пустым ID
+	id := dispatch(t, tm, "")
+	_, ok := tm.GetNextTaskFor("")
+	assert.False(t, ok, "no replica for an agent without ID")
+	_, err := tm.SaveTaskResultFrom(id, "", models.Float(5))
+	require.NoError(t, err)
+	expr, _ = tm.GetExpressionByID(expr.ID)
+	assert.Equal(t, "completed", expr.Status, "the task is accepted without verification")
+
+	// Задачи агентов с ID по-прежнему проверяются
+	expr, _ = tm.CreateExpression("4 + 5", Options{NoOptimize: true})
+	id = dispatch(t, tm, "a1")
+	_, ok = tm.GetNextTaskFor("")
+	assert.False(t, ok)
+	dispatch(t, tm, "a2")
+	tm.SaveTaskResultFrom(id, "a1", models.Float(9))
+	tm.SaveTaskResultFrom(id, "a2", models.Float(9))
+	task, _ := tm.GetTask(id)
+	assert.Equal(t, []string{"a1", "a2"}, task.VerifiedBy)
+}
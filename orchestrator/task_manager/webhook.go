@@ -0,0 +1,397 @@
+package task_manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/m1tka051209/arithmetic-service/orchestrator/models"
+)
+
+var (
+	ErrInvalidCallback  = errors.New("invalid callback_url")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrWebhookQueueFull = errors.New("webhook queue is full")
+	ErrWebhookSignature = errors.New("invalid webhook signature")
+	ErrWebhookTimestamp = errors.New("webhook timestamp is outside the tolerance window")
+	errInternalAddress  = errors.New("internal address")
+)
+
+// maxDeadLetters — сколько недоставленных уведомлений хранится; старые вытесняются
+const maxDeadLetters = 1000
+
+// WebhookTolerance — насколько метка времени уведомления может расходиться
+// с часами получателя. Уведомление с меткой старше — повтор перехваченного запроса.
+const WebhookTolerance = 5 * time.Minute
+
+// WebhookDelivery — уведомление о завершении выражения, отправляемое на его
+// callback_url. ID доставки совпадает с ID выражения.
+type WebhookDelivery struct {
+	ExpressionID string     `json:"expression_id"`
+	URL          string     `json:"url"`
+	Attempts     int        `json:"attempts"`
+	LastError    string     `json:"last_error,omitempty"`
+	FailedAt     *time.Time `json:"failed_at,omitempty"` // когда доставка попала в список недоставленных
+	payload      []byte
+	due          time.Time // когда повторить попытку
+}
+
+// webhookDispatcher отправляет уведомления в фоне: WEBHOOK_WORKERS воркеров
+// читают общую очередь, а один планировщик возвращает в неё уведомления, чья
+// задержка перед повтором истекла. Неудачная попытка повторяется с удвоением
+// задержки; уведомление, которое так и не удалось доставить, попадает
+// в список недоставленных, откуда его можно отправить заново.
+type webhookDispatcher struct {
+	client      *http.Client    // не соединяется с внутренними адресами
+	trusted     *http.Client    // для хостов из WEBHOOK_ALLOWED_HOSTS
+	allowed     map[string]bool // хосты, которым можно быть внутренними
+	secret      []byte          // ключ подписи; пустой — уведомления не подписываются
+	maxAttempts int
+	backoff     time.Duration // задержка перед второй попыткой, дальше удваивается
+	maxBackoff  time.Duration
+	workers     int
+	queue       chan WebhookDelivery // уведомления, готовые к отправке
+	wake        chan struct{}        // будит планировщик, когда появляется повтор
+	start       sync.Once            // воркеры запускаются с первым уведомлением
+
+	mu          sync.Mutex
+	pending     int               // уведомления в очереди, в отправке и ожидающие повтора; не больше cap(queue)
+	retries     []WebhookDelivery // ожидающие повтора
+	deadLetters []WebhookDelivery // в порядке отказа
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	timeout := getIntervalFromEnv("WEBHOOK_TIMEOUT", 10*time.Second)
+	return &webhookDispatcher{
+		client:      newWebhookClient(timeout, true),
+		trusted:     newWebhookClient(timeout, false),
+		allowed:     parseHosts(os.Getenv("WEBHOOK_ALLOWED_HOSTS")),
+		secret:      []byte(os.Getenv("WEBHOOK_SECRET")),
+		maxAttempts: max(getIntFromEnv("WEBHOOK_MAX_ATTEMPTS", 5), 1),
+		backoff:     getIntervalFromEnv("WEBHOOK_BACKOFF", time.Second),
+		maxBackoff:  getIntervalFromEnv("WEBHOOK_MAX_BACKOFF", 5*time.Minute),
+		workers:     max(getIntFromEnv("WEBHOOK_WORKERS", 4), 1),
+		queue:       make(chan WebhookDelivery, max(getIntFromEnv("WEBHOOK_QUEUE_SIZE", 1000), 1)),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// newWebhookClient — клиент уведомлений: без прокси и без перенаправлений,
+// иначе получатель увёл бы запрос на другой адрес. С guard соединение
+// с внутренним адресом запрещается уже после разрешения имени, поэтому
+// не помогает и имя, которое после проверки стало указывать на 127.0.0.1.
+func newWebhookClient(timeout time.Duration, guard bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if guard {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip, err := netip.ParseAddr(host); err != nil || internalAddr(ip) {
+				return fmt.Errorf("%w %s", errInternalAddress, host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// parseHosts разбирает список хостов через запятую
+func parseHosts(list string) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts[host] = true
+		}
+	}
+	return hosts
+}
+
+// internalAddr сообщает, что адрес не должен получать уведомления: loopback,
+// частные сети, link-local (в том числе метаданные облака 169.254.169.254),
+// multicast и неуказанный адрес
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// WebhookSignature — значение заголовка X-Signature-256: HMAC-SHA256 строки
+// "<timestamp>.<тело>", где timestamp — заголовок X-Webhook-Timestamp
+func WebhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook проверяет уведомление так, как это делает получатель: подпись
+// должна совпасть, а метка времени — отличаться от now не больше чем на WebhookTolerance
+func VerifyWebhook(secret []byte, timestamp, signature string, body []byte, now time.Time) error {
+	if !hmac.Equal([]byte(signature), []byte(WebhookSignature(secret, timestamp, body))) {
+		return ErrWebhookSignature
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookTimestamp
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > WebhookTolerance || skew < -WebhookTolerance {
+		return ErrWebhookTimestamp
+	}
+	return nil
+}
+
+// validateCallback проверяет, что callback_url — абсолютный адрес http(s),
+// который не ведёт во внутреннюю сеть. Хосты из WEBHOOK_ALLOWED_HOSTS не проверяются.
+func (w *webhookDispatcher) validateCallback(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCallback, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" {
+		return fmt.Errorf("%w: expected an absolute http or https URL", ErrInvalidCallback)
+	}
+	host := strings.ToLower(u.Hostname())
+	if w.allowed[host] {
+		return nil
+	}
+
+	addrs := []netip.Addr{}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, ip)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+			return fmt.Errorf("%w: cannot resolve %s", ErrInvalidCallback, host)
+		}
+	}
+	for _, ip := range addrs {
+		if internalAddr(ip) {
+			return fmt.Errorf("%w: %s is an internal address", ErrInvalidCallback, host)
+		}
+	}
+	return nil
+}
+
+// notify ставит уведомление о завершённом выражении в очередь отправки.
+// Вызывается под tm.mu и не ждёт доставки.
+func (tm *TaskManager) notify(expr models.Expression) {
+	if expr.CallbackURL == "" {
+		return
+	}
+	d := WebhookDelivery{ExpressionID: expr.ID, URL: expr.CallbackURL}
+	payload, err := json.Marshal(expr)
+	if err != nil {
+		d.LastError = err.Error()
+		tm.webhooks.bury(d)
+		return
+	}
+	d.payload = payload
+
+	w := tm.webhooks
+	w.start.Do(w.run)
+	w.mu.Lock()
+	err = w.push(d)
+	w.mu.Unlock()
+	if err != nil {
+		// Очередь ограничена, чтобы неотвечающие получатели не съели память
+		d.LastError = err.Error()
+		w.bury(d)
+	}
+}
+
+// push ставит уведомление в очередь, если в работе меньше cap(queue) уведомлений.
+// Вызывается под w.mu; не блокируется: в канале не больше pending уведомлений.
+func (w *webhookDispatcher) push(d WebhookDelivery) error {
+	if w.pending >= cap(w.queue) {
+		return ErrWebhookQueueFull
+	}
+	w.pending++
+	w.queue <- d
+	return nil
+}
+
+// run запускает воркеры и планировщик повторов
+func (w *webhookDispatcher) run() {
+	for i := 0; i < w.workers; i++ {
+		go func() {
+			for d := range w.queue {
+				w.send(d)
+			}
+		}()
+	}
+	go w.schedule()
+}
+
+func (w *webhookDispatcher) send(d WebhookDelivery) {
+	retry, err := w.post(d)
+	d.Attempts++
+	if err != nil {
+		d.LastError = err.Error()
+	}
+
+	w.mu.Lock()
+	if err != nil && retry && d.Attempts < w.maxAttempts {
+		slog.Debug("webhook attempt failed", "expression_id", d.ExpressionID, "attempt", d.Attempts, "err", err)
+		d.due = time.Now().Add(w.delay(d.Attempts))
+		w.retries = append(w.retries, d)
+		w.mu.Unlock()
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	w.pending--
+	w.mu.Unlock()
+	if err != nil {
+		w.bury(d)
+	}
+}
+
+// schedule возвращает в очередь уведомления, задержка которых истекла.
+// Один таймер на все повторы, а не таймер на каждое уведомление.
+func (w *webhookDispatcher) schedule() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		var due []WebhookDelivery
+		next := time.Hour
+		now := time.Now()
+		w.mu.Lock()
+		waiting := w.retries[:0]
+		for _, d := range w.retries {
+			if d.due.After(now) {
+				waiting = append(waiting, d)
+				next = min(next, d.due.Sub(now))
+			} else {
+				due = append(due, d)
+			}
+		}
+		w.retries = waiting
+		w.mu.Unlock()
+		for _, d := range due {
+			w.queue <- d // уже учтено в pending, поэтому место в канале есть
+		}
+
+		timer.Reset(next)
+		select {
+		case <-timer.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// post делает одну попытку доставки. retry сообщает, имеет ли смысл повторять:
+// сетевые ошибки, 408, 429 и 5xx временные, остальные ответы и внутренний
+// адрес получателя — окончательный отказ.
+func (w *webhookDispatcher) post(d WebhookDelivery) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.payload))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", d.ExpressionID)
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(d.Attempts+1))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if len(w.secret) > 0 {
+		req.Header.Set("X-Signature-256", WebhookSignature(w.secret, timestamp, d.payload))
+	}
+
+	client := w.client
+	if w.allowed[strings.ToLower(req.URL.Hostname())] {
+		client = w.trusted
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return !errors.Is(err, errInternalAddress), err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		return false, nil
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+		return true, fmt.Errorf("receiver responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+}
+
+// delay — задержка перед попыткой attempts+1
+func (w *webhookDispatcher) delay(attempts int) time.Duration {
+	d := w.backoff
+	for i := 1; i < attempts && d < w.maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, w.maxBackoff)
+}
+
+func (w *webhookDispatcher) bury(d WebhookDelivery) {
+	slog.Warn("webhook delivery failed", "expression_id", d.ExpressionID, "url", d.URL, "attempts", d.Attempts, "err", d.LastError)
+	now := time.Now()
+	d.FailedAt = &now
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.deadLetters = append(w.deadLetters, d)
+	if len(w.deadLetters) > maxDeadLetters {
+		w.deadLetters = w.deadLetters[len(w.deadLetters)-maxDeadLetters:]
+	}
+}
+
+// DeadLetters возвращает недоставленные уведомления в порядке отказа
+func (tm *TaskManager) DeadLetters() []WebhookDelivery {
+	w := tm.webhooks
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]WebhookDelivery{}, w.deadLetters...)
+}
+
+// RetryDeadLetter убирает уведомление из списка недоставленных и отправляет
+// его заново с полным запасом попыток. Если очередь заполнена, уведомление
+// остаётся в списке, а ошибка — ErrWebhookQueueFull.
+func (tm *TaskManager) RetryDeadLetter(exprID string) error {
+	w := tm.webhooks
+	w.start.Do(w.run)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, d := range w.deadLetters {
+		if d.ExpressionID != exprID {
+			continue
+		}
+		d.Attempts, d.LastError, d.FailedAt = 0, "", nil
+		if err := w.push(d); err != nil {
+			return err
+		}
+		w.deadLetters = append(w.deadLetters[:i:i], w.deadLetters[i+1:]...)
+		return nil
+	}
+	return ErrDeliveryNotFound
+}
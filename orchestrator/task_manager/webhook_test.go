@@ -0,0 +1,255 @@
+package task_manager
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRequest — уведомление, принятое тестовым получателем
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver поднимает локальный получатель уведомлений. status возвращает
+// код ответа на попытку с номером attempt (с единицы).
+func newReceiver(t *testing.T, status func(attempt int) int) (*httptest.Server, <-chan webhookRequest) {
+	received := make(chan webhookRequest, 16)
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- webhookRequest{header: r.Header, body: body}
+		w.WriteHeader(status(int(attempts.Add(1))))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func newWebhookTaskManager() *TaskManager {
+	tm := NewTaskManager()
+	tm.webhooks.secret = []byte("s3cret")
+	tm.webhooks.backoff = time.Millisecond
+	tm.webhooks.maxAttempts = 3
+	tm.webhooks.allowed = parseHosts("127.0.0.1")
+	return tm
+}
+
+func receive(t *testing.T, received <-chan webhookRequest) webhookRequest {
+	t.Helper()
+	select {
+	case req := <-received:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+		return webhookRequest{}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	srv, received := newReceiver(t, func(int) int { return http.StatusOK })
+	tm := newWebhookTaskManager()
+
+	expr, err := tm.CreateExpression("(2 + 3) * 4", Options{NoOptimize: true, CallbackURL: srv.URL})
+	require.NoError(t, err)
+	runAgent(tm)
+
+	req := receive(t, received)
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	assert.Equal(t, expr.ID, req.header.Get("X-Webhook-ID"))
+	assert.Equal(t, "1", req.header.Get("X-Webhook-Attempt"))
+	timestamp := req.header.Get("X-Webhook-Timestamp")
+	assert.Equal(t, WebhookSignature([]byte("s3cret"), timestamp, req.body), req.header.Get("X-Signature-256"))
+	assert.NoError(t, VerifyWebhook([]byte("s3cret"), timestamp, req.header.Get("X-Signature-256"), req.body, time.Now()))
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal(req.body, &payload))
+	assert.Equal(t, expr.ID, payload["id"])
+	assert.Equal(t, "completed", payload["status"])
+	assert.Equal(t, 20.0, payload["result"])
+	assert.NotContains(t, payload, "callback_url")
+
+	// Ошибка вычисления — тоже конечное состояние
+	expr, _ = tm.CreateExpression("1 / (2 - 2)", Options{NoOptimize: true, CallbackURL: srv.URL})
+	runAgent(tm)
+	req = receive(t, received)
+	require.NoError(t, json.Unmarshal(req.body, &payload))
+	assert.Equal(t, expr.ID, payload["id"])
+	assert.Equal(t, "error", payload["status"])
+
+	// Выражение, вычисленное сразу при создании
+	expr, _ = tm.CreateExpression("2 + 3", Options{CallbackURL: srv.URL})
+	req = receive(t, received)
+	assert.Equal(t, expr.ID, req.header.Get("X-Webhook-ID"))
+	assert.Empty(t, tm.DeadLetters())
+}
+
+func TestWebhookRetries(t *testing.T) {
+	srv, received := newReceiver(t, func(attempt int) int {
+		if attempt < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	tm := newWebhookTaskManager()
+
+	_, err := tm.CreateExpression("2 + 3", Options{CallbackURL: srv.URL})
+	require.NoError(t, err)
+	for attempt := 1; attempt <= 3; attempt++ {
+		req := receive(t, received)
+		assert.Equal(t, strconv.Itoa(attempt), req.header.Get("X-Webhook-Attempt"))
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, tm.DeadLetters())
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	var fixed atomic.Bool
+	srv, received := newReceiver(t, func(int) int {
+		if fixed.Load() {
+			return http.StatusOK
+		}
+		return http.StatusServiceUnavailable
+	})
+	rejecting, rejected := newReceiver(t, func(int) int { return http.StatusBadRequest })
+	tm := newWebhookTaskManager()
+
+	// Временные ошибки повторяются до исчерпания попыток
+	failed, _ := tm.CreateExpression("2 + 3", Options{CallbackURL: srv.URL})
+	for i := 0; i < 3; i++ {
+		receive(t, received)
+	}
+	// Окончательный отказ не повторяется
+	refused, _ := tm.CreateExpression("2 + 4", Options{CallbackURL: rejecting.URL})
+	receive(t, rejected)
+
+	require.Eventually(t, func() bool { return len(tm.DeadLetters()) == 2 }, 5*time.Second, time.Millisecond)
+	letters := map[string]WebhookDelivery{}
+	for _, d := range tm.DeadLetters() {
+		letters[d.ExpressionID] = d
+	}
+	assert.Equal(t, 3, letters[failed.ID].Attempts)
+	assert.Equal(t, "receiver responded 503 Service Unavailable", letters[failed.ID].LastError)
+	assert.NotNil(t, letters[failed.ID].FailedAt)
+	assert.Equal(t, 1, letters[refused.ID].Attempts)
+	assert.Equal(t, rejecting.URL, letters[refused.ID].URL)
+
+	fixed.Store(true)
+	require.NoError(t, tm.RetryDeadLetter(failed.ID))
+	req := receive(t, received)
+	assert.Equal(t, failed.ID, req.header.Get("X-Webhook-ID"))
+	assert.Equal(t, "1", req.header.Get("X-Webhook-Attempt"))
+	assert.Len(t, tm.DeadLetters(), 1)
+
+	assert.ErrorIs(t, tm.RetryDeadLetter(failed.ID), ErrDeliveryNotFound)
+}
+
+func TestWebhookCallbackValidation(t *testing.T) {
+	tm := NewTaskManager()
+	for _, callback := range []string{
+		"ftp://example.com/hook", "/hook", "http://", "http://exa mple.com",
+		// Внутренние адреса: иначе уведомление стало бы запросом от имени оркестратора
+		"http://127.0.0.1:8080/admin/agents", "http://localhost/hook", "http://10.0.0.7/hook",
+		"http://192.168.1.1/", "http://169.254.169.254/latest/meta-data/", "http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook", "http://0.0.0.0/", "http://[fe80::1]/", "http://224.0.0.1/",
+	} {
+		_, err := tm.CreateExpression("2 + 3", Options{CallbackURL: callback})
+		assert.ErrorIs(t, err, ErrInvalidCallback, callback)
+	}
+	assert.Empty(t, tm.GetAllExpressions())
+
+	w := newWebhookDispatcher()
+	assert.NoError(t, w.validateCallback(context.Background(), "https://203.0.113.10/hook"))
+	w.allowed = parseHosts(" LocalHost , 10.0.0.7")
+	for _, callback := range []string{"http://localhost:9000/hook", "http://10.0.0.7/hook"} {
+		assert.NoError(t, w.validateCallback(context.Background(), callback), callback)
+	}
+}
+
+// Проверка при создании не спасает, если имя потом укажет на внутренний адрес:
+// соединение проверяется ещё раз, уже с разрешённым адресом
+func TestWebhookRefusesInternalAddressOnDial(t *testing.T) {
+	srv, received := newReceiver(t, func(int) int { return http.StatusOK })
+	w := newWebhookDispatcher()
+
+	retry, err := w.post(WebhookDelivery{ExpressionID: "abc", URL: srv.URL})
+	assert.ErrorIs(t, err, errInternalAddress)
+	assert.False(t, retry, "an internal address will not change on retry")
+	assert.Empty(t, received)
+
+	w.allowed = parseHosts("127.0.0.1")
+	_, err = w.post(WebhookDelivery{ExpressionID: "abc", URL: srv.URL})
+	assert.NoError(t, err)
+	receive(t, received)
+}
+
+func TestWebhookRedirectsAreNotFollowed(t *testing.T) {
+	target, received := newReceiver(t, func(int) int { return http.StatusOK })
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+	w := newWebhookDispatcher()
+	w.allowed = parseHosts("127.0.0.1")
+
+	retry, err := w.post(WebhookDelivery{ExpressionID: "abc", URL: redirect.URL})
+	assert.EqualError(t, err, "receiver responded 307 Temporary Redirect")
+	assert.False(t, retry)
+	assert.Empty(t, received)
+}
+
+func TestWebhookQueueIsBounded(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	tm := newWebhookTaskManager()
+	tm.webhooks.workers = 1
+	tm.webhooks.queue = make(chan WebhookDelivery, 2)
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		expr, err := tm.CreateExpression("2 + "+strconv.Itoa(i), Options{CallbackURL: srv.URL})
+		require.NoError(t, err)
+		ids = append(ids, expr.ID)
+	}
+	// Два уведомления в работе, остальные сразу недоставлены
+	letters := tm.DeadLetters()
+	require.Len(t, letters, 2)
+	assert.Equal(t, ids[2], letters[0].ExpressionID)
+	assert.Equal(t, "webhook queue is full", letters[0].LastError)
+	assert.ErrorIs(t, tm.RetryDeadLetter(ids[2]), ErrWebhookQueueFull)
+	assert.Len(t, tm.DeadLetters(), 2, "a refused retry stays in the list")
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	secret, body := []byte("s3cret"), []byte(`{"id":"abc"}`)
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := WebhookSignature(secret, timestamp, body)
+
+	assert.NoError(t, VerifyWebhook(secret, timestamp, signature, body, now.Add(WebhookTolerance)))
+	assert.ErrorIs(t, VerifyWebhook(secret, timestamp, signature, body, now.Add(WebhookTolerance+time.Second)), ErrWebhookTimestamp)
+	assert.ErrorIs(t, VerifyWebhook(secret, timestamp, signature, []byte(`{"id":"xyz"}`), now), ErrWebhookSignature)
+	// Метку времени нельзя подменить, не пересчитав подпись
+	fresh := strconv.FormatInt(now.Add(time.Hour).Unix(), 10)
+	assert.ErrorIs(t, VerifyWebhook(secret, fresh, signature, body, now.Add(time.Hour)), ErrWebhookSignature)
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	w := &webhookDispatcher{backoff: time.Second, maxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, w.delay(1))
+	assert.Equal(t, 2*time.Second, w.delay(2))
+	assert.Equal(t, 4*time.Second, w.delay(3))
+	assert.Equal(t, 5*time.Second, w.delay(4))
+	assert.Equal(t, 5*time.Second, w.delay(40))
+}
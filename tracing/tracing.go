@@ -0,0 +1,80 @@
+// Package tracing настраивает OpenTelemetry для оркестратора и агента.
+// Одно выражение — одна трасса: от запроса на вычисление через разбор,
+// ожидание задач в очереди и их выполнение агентами до приёма результатов.
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	mu       sync.Mutex
+	provider *sdktrace.TracerProvider // nil, пока трассировка выключена
+)
+
+// Setup включает трассировку, если задан OTEL_EXPORTER_OTLP_ENDPOINT
+// или OTEL_EXPORTER_OTLP_TRACES_ENDPOINT: спаны отправляются коллектору
+// по OTLP/HTTP. Остальные настройки экспортёра и ресурса берутся из
+// стандартных переменных OTEL_*; OTEL_SERVICE_NAME заменяет service.
+// Контекст трассы передаётся в заголовках W3C traceparent в любом случае.
+func Setup(ctx context.Context, service string) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("tracing error", "err", err)
+	}))
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return err
+	}
+	install(sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)))
+	return nil
+}
+
+// InMemory включает трассировку со спанами в памяти — для тестов.
+// Спан попадает в экспортёр сразу после завершения.
+func InMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	install(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
+
+func install(tp *sdktrace.TracerProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+	provider = tp
+	otel.SetTracerProvider(tp)
+}
+
+// Shutdown отправляет накопленные спаны перед завершением процесса
+func Shutdown(ctx context.Context) error {
+	mu.Lock()
+	tp := provider
+	mu.Unlock()
+	if tp == nil {
+		return nil
+	}
+	return tp.Shutdown(ctx)
+}